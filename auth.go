@@ -86,3 +86,32 @@ func (a *auth) ValidateTwitchToken(t string) (*jwt.Token, error) {
 
 	})
 }
+
+var errInvalidProvider = errors.New("invalid provider")
+
+// identify validates an ID token from provider and returns the user's subject
+func (s *server) identify(provider string, token string) (string, error) {
+	var t *jwt.Token
+	var err error
+	switch provider {
+	case "twitch":
+		t, err = s.auth.ValidateTwitchToken(token)
+	case "google":
+		s.auth.getGooglePEM()
+		t, err = s.auth.ValidateGoogleToken(token)
+	default:
+		if _testing {
+			return "test", nil
+		}
+		return "", errInvalidProvider
+	}
+	if err != nil {
+		return "", err
+	}
+
+	sub, _ := t.Claims.(jwt.MapClaims)["sub"].(string)
+	if sub == "" {
+		return "", errors.New("token: missing sub")
+	}
+	return sub, nil
+}
@@ -0,0 +1,135 @@
+package pogifyapi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ban identifies a requester either by the requester ID hosts see on queued
+// requests or by their provider and subject
+type ban struct {
+	Requester string `json:"requester" form:"requester"`
+	Provider  string `json:"provider" form:"provider"`
+	Sub       string `json:"sub" form:"sub"`
+	// ExpiresIn is in seconds, up to a day. Bans without it last until the
+	// session ends.
+	ExpiresIn int64 `json:"expiresIn" binding:"min=0,max=86400"`
+}
+
+// requester returns the requester ID b is for
+func (b *ban) requester() (string, error) {
+	switch {
+	case b.Requester != "" && b.Provider == "" && b.Sub == "":
+		return b.Requester, nil
+	case b.Requester == "" && b.Provider != "" && b.Sub != "":
+		id := b.Sub
+		if b.Provider == providerListener {
+			id = providerListener + ":" + id
+		}
+		return requesterID(b.Provider, id), nil
+	}
+
+	return "", errors.New("ban needs either requester or provider and sub")
+}
+
+// banRequester stops a requester from making requests in the session
+func (s *server) banRequester(c *gin.Context) {
+	principal, err := getPrincipal(c)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	var b ban
+	if err := c.ShouldBindJSON(&b); err != nil {
+		c.Error(err)
+		c.String(400, fmt.Sprint(err))
+		return
+	}
+	requester, err := b.requester()
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	var expiresAt int64
+	if b.ExpiresIn != 0 {
+		expiresAt = time.Now().Add(time.Duration(b.ExpiresIn) * time.Second).Unix()
+	}
+
+	if err := s.redis.banRequester(principal.Session, requester, expiresAt); err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"requester": requester,
+		"expiresAt": expiresAt,
+	})
+}
+
+// unbanRequester lifts a ban given by the requester or provider and sub
+// queries
+func (s *server) unbanRequester(c *gin.Context) {
+	principal, err := getPrincipal(c)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	var b ban
+	if err := c.ShouldBindQuery(&b); err != nil {
+		c.Error(err)
+		c.String(400, fmt.Sprint(err))
+		return
+	}
+	requester, err := b.requester()
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	unbanned, err := s.redis.unbanRequester(principal.Session, requester)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	if !unbanned {
+		c.String(404, "requester isn't banned")
+		return
+	}
+
+	c.String(200, "ok")
+}
+
+// getBans lists the session's banned requesters
+func (s *server) getBans(c *gin.Context) {
+	principal, err := getPrincipal(c)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	bans, err := s.redis.bans(principal.Session)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	list := make([]gin.H, 0, len(bans))
+	for requester, expiresAt := range bans {
+		list = append(list, gin.H{
+			"requester": requester,
+			"expiresAt": expiresAt,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["requester"].(string) < list[j]["requester"].(string)
+	})
+
+	c.JSON(200, gin.H{"bans": list})
+}
@@ -0,0 +1,158 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_server_bans(t *testing.T) {
+	hostToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, sessionJwtClaims{
+		Session: "exist",
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Issuer:    defaultJWTIssuer,
+			Audience:  defaultJWTAudience,
+		},
+	}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+	mr.Set("session:exist", "refresh")
+
+	router := gin.New()
+
+	Server(router.Group("/"))
+
+	send := func(method string, endpoint string, token string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, endpoint, strings.NewReader(body))
+		req.Header.Add("X-Session-Token", token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	// notaprovider identifies everyone as "test" while testing
+	requester := requesterID("notaprovider", "test")
+	makeRequest := func() int {
+		mr.Del(fmt.Sprintf("requestLimit:exist:%x", hashID("test")))
+		return send("POST", "/session/request", "", `{"session":"exist","provider":"notaprovider","token":"not.a.token","request":"spotify:track:4uLU6hMCjMI75M1A2tKUQC"}`).Code
+	}
+
+	t.Run("invalid bans", func(t *testing.T) {
+		for _, body := range []string{`{}`, `{"provider":"twitch"}`, `{"requester":"abc","provider":"twitch","sub":"123"}`, `{"requester":"abc","expiresIn":-1}`, `{"requester":"abc","expiresIn":9223372036}`} {
+			if w := send("POST", "/session/bans", hostToken, body); w.Code != 400 {
+				t.Errorf("banRequester with %v returned %v, expected %v", body, w.Code, 400)
+			}
+		}
+		if w := send("POST", "/session/bans", "", `{"requester":"abc"}`); w.Code != 400 {
+			t.Errorf("banRequester without a token returned %v, expected %v", w.Code, 400)
+		}
+	})
+
+	t.Run("ban by provider and sub", func(t *testing.T) {
+		w := send("POST", "/session/bans", hostToken, `{"provider":"notaprovider","sub":"test"}`)
+		if w.Code != 200 || w.Body.String() != fmt.Sprintf(`{"expiresAt":0,"requester":%q}`, requester) {
+			t.Fatalf("banRequester returned %v: %v", w.Code, w.Body.String())
+		}
+
+		if code := makeRequest(); code != 403 {
+			t.Errorf("makeRequest while banned returned %v, expected %v", code, 403)
+		}
+		if mr.Exists(fmt.Sprintf("requestLimit:exist:%x", hashID("test"))) {
+			t.Errorf("makeRequest while banned counted towards the rate limit")
+		}
+
+		var list struct {
+			Bans []struct {
+				Requester string `json:"requester"`
+				ExpiresAt int64  `json:"expiresAt"`
+			} `json:"bans"`
+		}
+		json.Unmarshal(send("GET", "/session/bans", hostToken, "").Body.Bytes(), &list)
+		if len(list.Bans) != 1 || list.Bans[0].Requester != requester {
+			t.Errorf("getBans returned %+v", list.Bans)
+		}
+	})
+
+	t.Run("unban", func(t *testing.T) {
+		if w := send("DELETE", "/session/bans", hostToken, ""); w.Code != 400 {
+			t.Errorf("unbanRequester without a requester returned %v, expected %v", w.Code, 400)
+		}
+		if w := send("DELETE", "/session/bans?requester="+requester, hostToken, ""); w.Code != 200 {
+			t.Errorf("unbanRequester returned %v, expected %v", w.Code, 200)
+		}
+		if code := makeRequest(); code != 200 {
+			t.Errorf("makeRequest after unban returned %v, expected %v", code, 200)
+		}
+		if w := send("DELETE", "/session/bans?provider=notaprovider&sub=test", hostToken, ""); w.Code != 404 {
+			t.Errorf("unbanRequester for an unbanned requester returned %v, expected %v", w.Code, 404)
+		}
+	})
+
+	t.Run("ban a listener from the queue", func(t *testing.T) {
+		var l struct {
+			Listener string `json:"listener"`
+			Token    string `json:"token"`
+		}
+		json.Unmarshal(send("POST", "/session/listener", "", `{"session":"exist"}`).Body.Bytes(), &l)
+		w := send("POST", "/session/request", "", fmt.Sprintf(`{"session":"exist","provider":"listener","token":%q,"request":"https://youtu.be/dQw4w9WgXcQ"}`, l.Token))
+		var q queuedRequest
+		json.Unmarshal(w.Body.Bytes(), &q)
+
+		w = send("POST", "/session/bans", hostToken, fmt.Sprintf(`{"provider":"listener","sub":%q,"expiresIn":60}`, l.Listener))
+		var b struct {
+			Requester string `json:"requester"`
+			ExpiresAt int64  `json:"expiresAt"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &b)
+		if b.Requester != q.Requester || b.ExpiresAt < time.Now().Unix()+59 || b.ExpiresAt > time.Now().Unix()+60 {
+			t.Fatalf("banRequester returned %v, expected a ban on %v for 60s", w.Body.String(), q.Requester)
+		}
+
+		vote := fmt.Sprintf(`{"session":"exist","provider":"listener","token":%q,"request":%q,"vote":1}`, l.Token, q.ID)
+		if w := send("POST", "/session/requests/vote", "", vote); w.Code != 403 {
+			t.Errorf("vote while banned returned %v, expected %v", w.Code, 403)
+		}
+
+		// the ban has expired
+		mr.HSet("session:exist:bans", q.Requester, fmt.Sprint(time.Now().Unix()-1))
+		if w := send("POST", "/session/requests/vote", "", vote); w.Code != 200 {
+			t.Errorf("vote after the ban expired returned %v, expected %v", w.Code, 200)
+		}
+		if mr.HGet("session:exist:bans", q.Requester) != "" {
+			t.Errorf("expired ban wasn't deleted")
+		}
+	})
+
+	t.Run("expired bans aren't listed", func(t *testing.T) {
+		mr.HSet("session:exist:bans", "expired", fmt.Sprint(time.Now().Unix()-1))
+		mr.HSet("session:exist:bans", "current", "0")
+
+		var list struct {
+			Bans []struct {
+				Requester string `json:"requester"`
+			} `json:"bans"`
+		}
+		json.Unmarshal(send("GET", "/session/bans", hostToken, "").Body.Bytes(), &list)
+		if len(list.Bans) != 1 || list.Bans[0].Requester != "current" {
+			t.Errorf("getBans returned %+v", list.Bans)
+		}
+		if mr.HGet("session:exist:bans", "expired") != "" {
+			t.Errorf("expired ban wasn't deleted")
+		}
+	})
+}
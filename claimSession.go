@@ -13,9 +13,41 @@ import (
 
 type sessionJwtClaims struct {
 	Session string `json:"session"`
+	// Role is empty for the host
+	Role string `json:"role,omitempty"`
+	// Scopes limit what a delegate can do
+	Scopes []string `json:"scopes,omitempty"`
+	// PasswordVersion is the version of the session password a listener
+	// token was issued for
+	PasswordVersion int64 `json:"pwv,omitempty"`
 	jwt.StandardClaims
 }
 
+// roleListener tokens let listeners into private sessions
+const roleListener = "listener"
+
+// roleAnonymous tokens identify listeners without a third party account
+const roleAnonymous = "anonymous"
+
+// roleDelegate tokens let co-hosts act for the host within their scopes
+const roleDelegate = "delegate"
+
+// tokenRevoked returns whether a session token was revoked, either by its ID
+// or by its session ending
+func (s *server) tokenRevoked(claims *sessionJwtClaims) (bool, error) {
+	ended, err := s.redis.sessionEnded(claims.Session, claims.IssuedAt)
+	if err != nil || ended {
+		return ended, err
+	}
+
+	// tokens from before IDs were issued can't be revoked individually
+	if claims.Id == "" {
+		return false, nil
+	}
+
+	return s.redis.tokenRevoked(claims.Id)
+}
+
 // StartSession ...
 func (s *server) claimSession(c *gin.Context) {
 
@@ -27,13 +59,31 @@ func (s *server) claimSession(c *gin.Context) {
 	}
 	sessionCode = sid.(string)
 
+	s.startSession(c, sessionCode, "")
+}
+
+// startSession creates the session sessionCode and responds with its tokens.
+// Vanity codes can only be started by their owner, which is empty for random
+// codes.
+func (s *server) startSession(c *gin.Context, sessionCode string, owner string) {
+	// new tokens can only be told apart from an ended session's by the second
+	// they were issued in
+	if ended, err := s.redis.sessionEnded(sessionCode, time.Now().Unix()); err != nil {
+		c.AbortWithError(500, err)
+		return
+	} else if ended {
+		c.Header("retry-after", "1")
+		c.String(409, "session just ended")
+		return
+	}
+
 	refreshToken, err := gonanoid.ID(64)
 	if err != nil {
 		c.AbortWithError(500, err)
 		return
 	}
 
-	val, err := s.redis.newSession(sessionCode, refreshToken)
+	val, err := s.redis.newSession(sessionCode, refreshToken, owner)
 
 	if err != nil {
 		log.Print(err)
@@ -41,21 +91,18 @@ func (s *server) claimSession(c *gin.Context) {
 		return
 	}
 
-	if val != 1 {
-		c.String(410, "code taken")
+	// vanity codes are claimed by their owner without a problem
+	if val == -1 {
+		c.String(410, "code reserved")
 		return
 	}
 
-	claims := sessionJwtClaims{
-		sessionCode,
-		jwt.StandardClaims{
-			ExpiresAt: time.Now().Unix() + 60*60,
-		},
+	if val != 1 {
+		c.String(410, "code taken")
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	tokenSign, err := token.SignedString(s.jwt.secret)
+	tokenSign, err := s.newSessionToken(sessionCode)
 
 	if err != nil {
 		c.AbortWithError(500, err)
@@ -65,7 +112,7 @@ func (s *server) claimSession(c *gin.Context) {
 	c.JSON(200, gin.H{
 		"session":      sessionCode,
 		"refreshToken": refreshToken,
-		"expiresIn":    60 * 60,
+		"expiresIn":    s.jwt.ttl.Seconds(),
 		"token":        tokenSign,
 	})
 }
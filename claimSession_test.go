@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 )
 
@@ -100,6 +101,21 @@ func Test_server_claimSession(t *testing.T) {
 		if err := json.Unmarshal(w2.Body.Bytes(), &session); err != nil {
 			t.Error(err)
 		}
+
+		if session.ExpiresIn != 60*60 {
+			t.Errorf("claimSession returned expiresIn %v, expected %v", session.ExpiresIn, 60*60)
+		}
+
+		claims := new(sessionJwtClaims)
+		jwt.ParseWithClaims(session.Token, claims, func(t *jwt.Token) (interface{}, error) {
+			return []byte(os.Getenv("JWT_SECRET")), nil
+		})
+		if claims.Issuer != defaultJWTIssuer || claims.Audience != defaultJWTAudience {
+			t.Errorf("claimSession issued token with iss %q and aud %q", claims.Issuer, claims.Audience)
+		}
+		if claims.IssuedAt == 0 || claims.NotBefore != claims.IssuedAt || claims.ExpiresAt-claims.IssuedAt != 60*60 {
+			t.Errorf("claimSession issued token with iat %v, nbf %v and exp %v", claims.IssuedAt, claims.NotBefore, claims.ExpiresAt)
+		}
 	})
 	t.Run("Test already claimed session /session/claim", func(t *testing.T) {
 		w1 := httptest.NewRecorder()
@@ -146,9 +162,30 @@ func Test_server_claimSession(t *testing.T) {
 			t.Errorf("got unexpected response code %v, expected %v", w2.Code, expect)
 		}
 	})
+	t.Run("Test reserved vanity code /session/claim", func(t *testing.T) {
+		mr.FlushAll()
+		mr.Set("vanity:test1", "owner")
+
+		w := solveClaim(t, router)
+		if expect := http.StatusGone; w.Code != expect || w.Body.String() != "code reserved" {
+			t.Errorf("got unexpected response %v %v, expected %v", w.Code, w.Body.String(), expect)
+		}
+		if mr.Exists("session:test1") {
+			t.Errorf("claimSession claimed a reserved vanity code")
+		}
+	})
 
 }
 
+// solveClaim issues a problem, solves it and claims the session
+func solveClaim(t *testing.T, router *gin.Engine) *httptest.ResponseRecorder {
+	jsonSol, _ := json.Marshal(solveProblem(t, router))
+	w2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("POST", "/session/claim", bytes.NewReader(jsonSol))
+	router.ServeHTTP(w2, req2)
+	return w2
+}
+
 func findSolution(nonce string, difficulty int) (s string, hash string) {
 	s = "0"
 	prefix := strings.Repeat("0", difficulty)
@@ -165,3 +202,31 @@ func findSolution(nonce string, difficulty int) (s string, hash string) {
 	}
 
 }
+
+// solveProblem issues a PoW problem and returns the body that claims it
+func solveProblem(t *testing.T, router *gin.Engine) map[string]interface{} {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/session/issue", nil)
+	router.ServeHTTP(w, req)
+
+	var p struct {
+		SessionID  string `json:"sessionId"`
+		Difficulty int    `json:"difficulty"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+
+	issued := time.Now().Unix()
+	idIss := fmt.Sprintf("%v.%v", p.SessionID, issued)
+	cs := sha256.Sum256([]byte(idIss + os.Getenv("POW_SECRET")))
+	s, h := findSolution(idIss, p.Difficulty)
+
+	return map[string]interface{}{
+		"sessionId": p.SessionID,
+		"issued":    issued,
+		"checksum":  hex.EncodeToString(cs[:]),
+		"solution":  s,
+		"hash":      h,
+	}
+}
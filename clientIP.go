@@ -0,0 +1,73 @@
+package pogifyapi
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseTrustedProxies parses a comma separated list of CIDRs or IPs
+func parseTrustedProxies(v string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, p := range strings.Split(v, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy %v", p)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			p = fmt.Sprintf("%v/%v", p, bits)
+		}
+		_, cidr, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, cidr)
+	}
+	return proxies, nil
+}
+
+// trustedProxy returns whether ip is one of TRUSTED_PROXIES
+func (s *server) trustedProxy(ip net.IP) bool {
+	for _, cidr := range s.trustedProxies {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address to rate limit a request by. gin's ClientIP
+// believes X-Forwarded-For from anyone, so it's only read here when the
+// request came through TRUSTED_PROXIES, and then only up to the first hop
+// that isn't one of them.
+func (s *server) clientIP(c *gin.Context) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+
+	hops := strings.Split(c.GetHeader("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0 && s.trustedProxy(ip); i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+	}
+
+	return ip.String()
+}
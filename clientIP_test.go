@@ -0,0 +1,56 @@
+package pogifyapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Test_parseTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1,::1")
+	if err != nil {
+		t.Fatalf("parseTrustedProxies errored with: %v", err)
+	}
+	if len(proxies) != 3 || proxies[1].String() != "192.0.2.1/32" || proxies[2].String() != "::1/128" {
+		t.Errorf("parseTrustedProxies returned %v", proxies)
+	}
+
+	if _, err := parseTrustedProxies("not a proxy"); err == nil {
+		t.Error("parseTrustedProxies accepted an invalid proxy")
+	}
+}
+
+func Test_server_clientIP(t *testing.T) {
+	proxies, _ := parseTrustedProxies("10.0.0.0/8")
+	s := &server{trustedProxies: proxies}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"direct", "192.0.2.1:1234", "", "192.0.2.1"},
+		{"forwarded by an untrusted client", "192.0.2.1:1234", "203.0.113.7", "192.0.2.1"},
+		{"forwarded by a trusted proxy", "10.0.0.1:1234", "203.0.113.7", "203.0.113.7"},
+		{"spoofed before a trusted proxy", "10.0.0.1:1234", "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		{"through several trusted proxies", "10.0.0.1:1234", "203.0.113.7, 10.0.0.2", "203.0.113.7"},
+		{"trusted proxy without the header", "10.0.0.1:1234", "", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request, _ = http.NewRequest("GET", "/", nil)
+			c.Request.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				c.Request.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+
+			if got := s.clientIP(c); got != tt.want {
+				t.Errorf("clientIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package pogifyapi
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gonanoid "github.com/matoous/go-nanoid"
+)
+
+// scopes a delegate can be given
+const (
+	scopeUpdate   = "update"
+	scopeConfig   = "config"
+	scopeRequests = "requests"
+)
+
+// maxDelegateTTL is the longest a delegate token can be valid for
+const maxDelegateTTL = 24 * time.Hour
+
+// delegate is a co-host token as tracked on the session
+type delegate struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name,omitempty"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt int64    `json:"expiresAt"`
+}
+
+type delegateRequest struct {
+	Name   string   `json:"name" binding:"max=64"`
+	Scopes []string `json:"scopes" binding:"required,min=1,dive,oneof=update config requests"`
+	// ExpiresIn is in seconds and defaults to the session token TTL
+	ExpiresIn int64 `json:"expiresIn" binding:"omitempty,min=60"`
+}
+
+// hasScope returns whether scopes contains scope
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// addDelegate issues the host's co-host a token limited to the requested
+// scopes
+func (s *server) addDelegate(c *gin.Context) {
+	principal, err := getPrincipal(c)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	var req delegateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err)
+		c.String(400, fmt.Sprint(err))
+		return
+	}
+
+	ttl := s.jwt.ttl
+	if req.ExpiresIn != 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if ttl > maxDelegateTTL {
+		ttl = maxDelegateTTL
+	}
+	// the token can't outlive the session it's for
+	remaining, err := s.redis.sessionTTL(principal.Session)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	if remaining < 0 {
+		c.String(404, "no such session")
+		return
+	}
+	if remaining > 0 && ttl > remaining {
+		ttl = remaining
+	}
+
+	jti, err := gonanoid.ID(21)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	d := delegate{
+		ID:        jti,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}
+	// track the delegate first so the token works as soon as it's returned
+	if err := s.redis.addDelegate(principal.Session, d, ttl); err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	claims := sessionJwtClaims{
+		Session: principal.Session,
+		Role:    roleDelegate,
+		Scopes:  req.Scopes,
+	}
+	claims.Id = jti
+	token, err := s.newToken(claims, ttl)
+	if err != nil {
+		go s.redis.removeDelegate(principal.Session, jti)
+		c.AbortWithError(500, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"delegate":  d,
+		"token":     token,
+		"expiresIn": ttl.Seconds(),
+	})
+}
+
+// getDelegates lists the session's co-hosts
+func (s *server) getDelegates(c *gin.Context) {
+	principal, err := getPrincipal(c)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	delegates, err := s.redis.delegates(principal.Session)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	c.JSON(200, gin.H{"delegates": delegates})
+}
+
+// removeDelegate revokes a co-host's token by its ID
+func (s *server) removeDelegate(c *gin.Context) {
+	principal, err := getPrincipal(c)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	id := c.Query("id")
+	if id == "" {
+		c.String(400, "no id query")
+		return
+	}
+
+	removed, err := s.redis.removeDelegate(principal.Session, id)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	if !removed {
+		c.String(404, "no such delegate")
+		return
+	}
+
+	c.String(200, "ok")
+}
@@ -0,0 +1,161 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_server_delegates(t *testing.T) {
+	hostToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, sessionJwtClaims{
+		Session: "test",
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Issuer:    defaultJWTIssuer,
+			Audience:  defaultJWTAudience,
+		},
+	}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+	mr.Set("session:test", "refresh")
+	mr.SetTTL("session:test", 2*maxDelegateTTL)
+
+	router := gin.New()
+
+	Server(router.Group("/"))
+
+	send := func(method string, endpoint string, token string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, endpoint, strings.NewReader(body))
+		req.Header.Add("X-Session-Token", token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	type delegateResponse struct {
+		Delegate  delegate `json:"delegate"`
+		Token     string   `json:"token"`
+		ExpiresIn float64  `json:"expiresIn"`
+	}
+	add := func(body string) delegateResponse {
+		w := send("POST", "/session/delegates", hostToken, body)
+		if w.Code != 200 {
+			t.Fatalf("addDelegate returned %v, expected %v: %v", w.Code, 200, w.Body.String())
+		}
+		var res delegateResponse
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return res
+	}
+
+	t.Run("invalid requests", func(t *testing.T) {
+		for _, body := range []string{`{}`, `{"scopes":[]}`, `{"scopes":["end"]}`, `{"scopes":["update"],"expiresIn":1}`} {
+			if w := send("POST", "/session/delegates", hostToken, body); w.Code != 400 {
+				t.Errorf("addDelegate with %v returned %v, expected %v", body, w.Code, 400)
+			}
+		}
+	})
+
+	updater := add(`{"name":"mod","scopes":["update"]}`)
+	configurer := add(`{"scopes":["config"],"expiresIn":172800}`)
+
+	t.Run("add", func(t *testing.T) {
+		if updater.Delegate.Name != "mod" || updater.ExpiresIn != time.Hour.Seconds() {
+			t.Errorf("addDelegate returned %+v", updater)
+		}
+		if configurer.ExpiresIn != maxDelegateTTL.Seconds() {
+			t.Errorf("addDelegate returned expiresIn %v, expected %v", configurer.ExpiresIn, maxDelegateTTL.Seconds())
+		}
+		if ttl := mr.TTL("session:test:delegates"); ttl != maxDelegateTTL {
+			t.Errorf("delegates expire in %v, expected %v", ttl, maxDelegateTTL)
+		}
+	})
+
+	t.Run("scopes", func(t *testing.T) {
+		if w := send("POST", "/session/update", updater.Token, testUpdate("spotify:track:a")); w.Code != 200 {
+			t.Errorf("postUpdate with update scope returned %v, expected %v: %v", w.Code, 200, w.Body.String())
+		}
+		if w := send("POST", "/session/config", updater.Token, `{"requestInterval":10}`); w.Code != 403 {
+			t.Errorf("setConfig with update scope returned %v, expected %v", w.Code, 403)
+		}
+		if w := send("POST", "/session/config", configurer.Token, `{"requestInterval":10}`); w.Code != 200 {
+			t.Errorf("setConfig with config scope returned %v, expected %v", w.Code, 200)
+		}
+		if w := send("POST", "/session/update", configurer.Token, testUpdate("spotify:track:a")); w.Code != 403 {
+			t.Errorf("postUpdate with config scope returned %v, expected %v", w.Code, 403)
+		}
+
+		// delegates can't act as the host
+		if w := send("POST", "/session/delegates", updater.Token, `{"scopes":["update"]}`); w.Code != 403 {
+			t.Errorf("addDelegate as a delegate returned %v, expected %v", w.Code, 403)
+		}
+		if w := send("POST", "/session/end", updater.Token, ""); w.Code != 403 {
+			t.Errorf("endSession as a delegate returned %v, expected %v", w.Code, 403)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		w := send("GET", "/session/delegates", hostToken, "")
+		var res struct {
+			Delegates []delegate `json:"delegates"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		if w.Code != 200 || len(res.Delegates) != 2 {
+			t.Errorf("getDelegates returned %v: %v", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("session expiry", func(t *testing.T) {
+		mr.SetTTL("session:test", time.Hour)
+		defer mr.SetTTL("session:test", 2*maxDelegateTTL)
+
+		d := add(`{"scopes":["requests"],"expiresIn":7200}`)
+		if d.ExpiresIn != time.Hour.Seconds() {
+			t.Errorf("addDelegate returned expiresIn %v, expected the session's remaining %v", d.ExpiresIn, time.Hour.Seconds())
+		}
+	})
+
+	t.Run("ended session", func(t *testing.T) {
+		mr.Del("session:test")
+		defer func() {
+			mr.Set("session:test", "refresh")
+			mr.SetTTL("session:test", 2*maxDelegateTTL)
+		}()
+
+		if w := send("POST", "/session/delegates", hostToken, `{"scopes":["update"]}`); w.Code != 404 {
+			t.Errorf("addDelegate on an ended session returned %v, expected %v", w.Code, 404)
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		if w := send("DELETE", "/session/delegates", hostToken, ""); w.Code != 400 {
+			t.Errorf("removeDelegate without id returned %v, expected %v", w.Code, 400)
+		}
+		if w := send("DELETE", "/session/delegates?id="+updater.Delegate.ID, hostToken, ""); w.Code != 200 {
+			t.Errorf("removeDelegate returned %v, expected %v", w.Code, 200)
+		}
+		if w := send("POST", "/session/update", updater.Token, testUpdate("spotify:track:b")); w.Code != 401 {
+			t.Errorf("postUpdate with removed delegate returned %v, expected %v", w.Code, 401)
+		}
+		if w := send("DELETE", "/session/delegates?id="+updater.Delegate.ID, hostToken, ""); w.Code != 404 {
+			t.Errorf("removing a removed delegate returned %v, expected %v", w.Code, 404)
+		}
+
+		// the other delegate still works
+		if w := send("POST", "/session/config", configurer.Token, `{"requestInterval":5}`); w.Code != 200 {
+			t.Errorf("setConfig with remaining delegate returned %v, expected %v", w.Code, 200)
+		}
+	})
+}
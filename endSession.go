@@ -0,0 +1,52 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionEndedEvent tells listeners the host has ended the session
+const sessionEndedEvent = "ended"
+
+func (s *server) endSession(c *gin.Context) {
+	principal, err := getPrincipal(c)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	if _, err := s.terminateSession(principal.Session); err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	c.String(200, "ok")
+}
+
+// terminateSession ends a session, invalidating every token issued for it, and
+// tells listeners. It returns the seq of the ended event.
+func (s *server) terminateSession(sessionID string) (int64, error) {
+	seq, err := s.redis.nextUpdateSeq(sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.redis.endSession(sessionID, s.maxTokenTTL()); err != nil {
+		return 0, err
+	}
+
+	msg, _ := json.Marshal(envelope{
+		Seq:  seq,
+		Time: MilliTime(time.Now()),
+		Type: sessionEndedEvent,
+	})
+	// the session is gone either way; listeners will time out if this fails
+	if _, err := s.pubsub.Publish(sessionID, msg); err != nil {
+		log.Printf("Pubsub error with: %v", err)
+	}
+
+	return seq, nil
+}
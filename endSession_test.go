@@ -0,0 +1,131 @@
+package pogifyapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_server_endSession(t *testing.T) {
+	sessionCode := "ending"
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, sessionJwtClaims{
+		Session: sessionCode,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Issuer:    defaultJWTIssuer,
+			Audience:  defaultJWTAudience,
+		},
+	})
+
+	mockToken, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		t.Fatalf("Error generating token: %v", err)
+	}
+
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+
+	router := gin.New()
+
+	Server(router.Group("/"))
+
+	post := func(endpoint string, token string, body string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", endpoint, strings.NewReader(body))
+		if token != "" {
+			req.Header.Add("X-Session-Token", token)
+		}
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("without token", func(t *testing.T) {
+		if code := post("/session/end", "", ""); code != 400 {
+			t.Errorf("endSession returned %v, expected %v", code, 400)
+		}
+	})
+
+	t.Run("with invalid token", func(t *testing.T) {
+		invalidToken, _ := token.SignedString([]byte("aaaa"))
+		if code := post("/session/end", invalidToken, ""); code != 401 {
+			t.Errorf("endSession returned %v, expected %v", code, 401)
+		}
+	})
+
+	t.Run("with token", func(t *testing.T) {
+		mr.Set("session:"+sessionCode, "refresh")
+		mr.HSet("session:"+sessionCode+":config", "RequestInterval", "10")
+		mr.Set("requestLimit:"+sessionCode+":abc", "1")
+		mr.SAdd("session:"+sessionCode+":requestLimits", "requestLimit:"+sessionCode+":abc")
+		mr.HSet("session:"+sessionCode+":delegates", "jti", "{}")
+		mr.HSet("session:"+sessionCode+":bans", "abc", "0")
+		mr.Set("session:other", "refresh")
+		mr.Set("requestLimit:other:abc", "1")
+		if code := post("/session/update", mockToken, testUpdate("spotify:track:a")); code != 200 {
+			t.Fatalf("postUpdate returned %v, expected %v", code, 200)
+		}
+
+		if code := post("/session/end", mockToken, ""); code != 200 {
+			t.Fatalf("endSession returned %v, expected %v", code, 200)
+		}
+
+		for _, key := range mr.Keys() {
+			if strings.Contains(key, sessionCode) && key != "ended:"+sessionCode {
+				t.Errorf("endSession left %v", key)
+			}
+		}
+		if !mr.Exists("session:other") || !mr.Exists("requestLimit:other:abc") {
+			t.Error("endSession deleted another session's keys")
+		}
+		if ttl := mr.TTL("ended:" + sessionCode); ttl != time.Hour {
+			t.Errorf("endSession set ttl %v on ended marker", ttl)
+		}
+
+		e := unwrap(t, _fakePublisher.last(sessionCode))
+		if e.Type != sessionEndedEvent || e.Seq != 2 {
+			t.Errorf("endSession published %+v, expected an ended event", e)
+		}
+	})
+
+	t.Run("token from ended session", func(t *testing.T) {
+		if code := post("/session/update", mockToken, testUpdate("spotify:track:a")); code != 401 {
+			t.Errorf("postUpdate returned %v, expected %v", code, 401)
+		}
+		if code := post("/session/config", mockToken, "{\"requestInterval\":100}"); code != 401 {
+			t.Errorf("setConfig returned %v, expected %v", code, 401)
+		}
+		if code := post("/session/end", mockToken, ""); code != 401 {
+			t.Errorf("endSession returned %v, expected %v", code, 401)
+		}
+	})
+
+	t.Run("token issued after session ended", func(t *testing.T) {
+		mr.Set("ended:"+sessionCode, fmt.Sprint(time.Now().Add(-time.Minute).Unix()))
+		newToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, sessionJwtClaims{
+			Session: sessionCode,
+			StandardClaims: jwt.StandardClaims{
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+				IssuedAt:  time.Now().Unix(),
+				Issuer:    defaultJWTIssuer,
+				Audience:  defaultJWTAudience,
+			},
+		}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+
+		if code := post("/session/update", newToken, testUpdate("spotify:track:a")); code != 200 {
+			t.Errorf("postUpdate returned %v, expected %v", code, 200)
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+)
+
+const (
+	// updateKeyframe envelopes carry the host's full state
+	updateKeyframe = "keyframe"
+	// updatePatch envelopes carry a JSON merge patch against the previous seq
+	updatePatch = "patch"
+)
+
+// keyframeInterval is how often a full state is published between patches
+const keyframeInterval = 10
+
+// envelope wraps every update published to listeners so they can detect
+// dropped or out-of-order updates by seq. Events that aren't kept in the
+// update history, like vote totals, have no seq.
+type envelope struct {
+	Seq     int64       `json:"seq,omitempty"`
+	Time    MilliTime   `json:"time"`
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload"`
+}
+
+// jsonOrString returns data as raw JSON if it's valid JSON or as a string
+// otherwise so it can be embedded in a JSON response
+func jsonOrString(data []byte) interface{} {
+	if json.Valid(data) {
+		return json.RawMessage(data)
+	}
+	return string(data)
+}
@@ -0,0 +1,40 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// unwrap decodes a published envelope
+func unwrap(t *testing.T, msg []byte) (e struct {
+	Seq     int64           `json:"seq"`
+	Time    int64           `json:"time"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}) {
+	t.Helper()
+	if err := json.Unmarshal(msg, &e); err != nil {
+		t.Fatalf("published message isn't an envelope: %s", msg)
+	}
+	return
+}
+
+func Test_jsonOrString(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want interface{}
+	}{
+		{"json", []byte("{\"a\":1}"), json.RawMessage("{\"a\":1}")},
+		{"not json", []byte("not json"), "not json"},
+		{"empty", []byte(""), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jsonOrString(tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("jsonOrString() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -27,6 +27,6 @@ func (s *server) getConfig(c *gin.Context) {
 		return
 	}
 
-	c.JSON(200, config)
+	c.JSON(200, config.public())
 
 }
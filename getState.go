@@ -0,0 +1,45 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *server) getState(c *gin.Context) {
+	id := c.Query("session")
+
+	if id == "" {
+		c.String(400, "no session query")
+		return
+	}
+
+	if !s.authorizeListener(c, id, c.GetHeader("X-Listener-Token")) {
+		return
+	}
+
+	state, err := s.redis.getState(id)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	if state == nil {
+		c.String(404, "No state for %s", id)
+		return
+	}
+
+	var e struct {
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(state.Data, &e); err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"seq":   state.Seq,
+		"state": e.Payload,
+		"time":  MilliTime(state.Time),
+	})
+}
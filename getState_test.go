@@ -0,0 +1,70 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_server_getState(t *testing.T) {
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+
+	router := gin.New()
+
+	Server(router.Group("/"))
+
+	t.Run("test get on missing query", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/session/state", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != 400 {
+			t.Errorf("getState didn't return 400 on empty query, instead: %#v", w.Code)
+		}
+	})
+
+	t.Run("test get on missing state", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/session/state?session=test", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != 404 {
+			t.Errorf("getState didn't return 404 on missing state, instead: %#v", w.Code)
+		}
+	})
+
+	t.Run("test get on existing state", func(t *testing.T) {
+		mr.HSet("session:test:state", "seq", "3", "data", "{\"seq\":3,\"time\":1600000000,\"payload\":{\"paused\":true}}", "time", "1600000000000000000")
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/session/state?session=test", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != 200 {
+			t.Fatalf("getState didn't return 200, instead: %#v", w.Code)
+		}
+
+		var body struct {
+			Seq   int64                  `json:"seq"`
+			State map[string]interface{} `json:"state"`
+			Time  int64                  `json:"time"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("getState returned invalid json: %v", err)
+		}
+
+		if body.Seq != 3 || body.State["paused"] != true || body.Time != 1600000000000 {
+			t.Errorf("getState returned unexpected body: %v", w.Body.String())
+		}
+	})
+}
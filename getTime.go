@@ -0,0 +1,36 @@
+package pogifyapi
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unixMillis returns t as fractional milliseconds since the epoch
+func unixMillis(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Millisecond)
+}
+
+// getTime lets clients estimate their clock offset NTP style. The client's
+// send time can be passed as t0 and is echoed back as originate.
+func (s *server) getTime(c *gin.Context) {
+	receive := time.Now()
+
+	res := gin.H{
+		"receive": unixMillis(receive),
+	}
+
+	if t0 := c.Query("t0"); t0 != "" {
+		originate, err := strconv.ParseFloat(t0, 64)
+		if err != nil {
+			c.String(400, "invalid t0 query")
+			return
+		}
+		res["originate"] = originate
+	}
+
+	c.Header("Cache-Control", "no-store")
+	res["transmit"] = unixMillis(time.Now())
+	c.JSON(200, res)
+}
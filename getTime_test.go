@@ -0,0 +1,75 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_server_getTime(t *testing.T) {
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+
+	router := gin.New()
+
+	Server(router.Group("/"))
+
+	t.Run("without t0", func(t *testing.T) {
+		before := unixMillis(time.Now())
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/session/time", nil)
+		router.ServeHTTP(w, req)
+
+		after := unixMillis(time.Now())
+
+		if w.Code != 200 {
+			t.Fatalf("getTime returned %v, expected %v", w.Code, 200)
+		}
+
+		var res map[string]float64
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("getTime returned invalid json: %v", err)
+		}
+
+		if _, ok := res["originate"]; ok {
+			t.Error("getTime returned originate without t0")
+		}
+		if res["receive"] < before || res["receive"] > res["transmit"] || res["transmit"] > after {
+			t.Errorf("getTime returned out of order times: %v, before %v, after %v", res, before, after)
+		}
+	})
+
+	t.Run("with t0", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/session/time?t0=1600000000000.5", nil)
+		router.ServeHTTP(w, req)
+
+		var res map[string]float64
+		json.Unmarshal(w.Body.Bytes(), &res)
+
+		if res["originate"] != 1600000000000.5 {
+			t.Errorf("getTime returned originate %v, expected %v", res["originate"], 1600000000000.5)
+		}
+	})
+
+	t.Run("invalid t0", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/session/time?t0=abc", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != 400 {
+			t.Errorf("getTime returned %v, expected %v", w.Code, 400)
+		}
+	})
+}
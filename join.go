@@ -0,0 +1,141 @@
+package pogifyapi
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	gonanoid "github.com/matoous/go-nanoid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// listenerTokenTTL is how long a listener can use a private session before
+// joining again
+const listenerTokenTTL = 30 * time.Minute
+
+// joinAttempts is how many passwords a client can try per session per minute
+const joinAttempts = 5
+
+// sessionJoinAttempts is how many passwords can be tried on a session per
+// minute altogether, so guesses are limited even from many addresses
+const sessionJoinAttempts = 30
+
+type joinRequest struct {
+	Session  string `json:"session" binding:"required"`
+	Password string `json:"password" binding:"required,max=72"`
+}
+
+// join issues a listener token for a private session once the password checks
+// out
+func (s *server) join(c *gin.Context) {
+	var req joinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err)
+		c.String(400, fmt.Sprint(err))
+		return
+	}
+
+	attempts, err := s.redis.rateLimitJoin(req.Session, s.clientIP(c))
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	if attempts > joinAttempts {
+		c.Header("retry-after", "60")
+		c.Status(429)
+		return
+	}
+	attempts, err = s.redis.rateLimitSessionJoin(req.Session)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	if attempts > sessionJoinAttempts {
+		c.Header("retry-after", "60")
+		c.Status(429)
+		return
+	}
+
+	hash, version, err := s.redis.sessionPassword(req.Session)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	if hash == "" {
+		c.String(400, "session isn't private")
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)); err != nil {
+		c.String(403, "wrong password")
+		return
+	}
+
+	listenerID, err := gonanoid.ID(21)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	token, err := s.newToken(sessionJwtClaims{
+		Session:         req.Session,
+		Role:            roleListener,
+		PasswordVersion: version,
+		StandardClaims:  jwt.StandardClaims{Subject: listenerID},
+	}, listenerTokenTTL)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"session":   req.Session,
+		"listener":  listenerID,
+		"token":     token,
+		"expiresIn": listenerTokenTTL.Seconds(),
+	})
+}
+
+// authorizeListener checks listenerToken if sessionID is private. If the
+// listener isn't allowed in it responds and returns false.
+func (s *server) authorizeListener(c *gin.Context, sessionID string, listenerToken string) bool {
+	hash, version, err := s.redis.sessionPassword(sessionID)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return false
+	}
+	if hash == "" {
+		return true
+	}
+
+	if listenerToken == "" {
+		c.String(401, "private session, join with the password first")
+		return false
+	}
+
+	claims, err := s.parseSessionToken(listenerToken)
+	if err != nil {
+		c.Error(err)
+		c.String(401, err.Error())
+		return false
+	}
+	if claims.Session != sessionID || claims.Role != roleListener {
+		c.String(403, "token is for a different session")
+		return false
+	}
+	if claims.PasswordVersion != version {
+		c.String(401, "password changed, join again")
+		return false
+	}
+
+	if revoked, err := s.tokenRevoked(claims); err != nil {
+		c.AbortWithError(500, err)
+		return false
+	} else if revoked {
+		c.String(401, "token revoked")
+		return false
+	}
+
+	return true
+}
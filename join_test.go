@@ -0,0 +1,209 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_server_join(t *testing.T) {
+	sessionCode := "exist"
+	hostToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, sessionJwtClaims{
+		Session: sessionCode,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Issuer:    defaultJWTIssuer,
+			Audience:  defaultJWTAudience,
+		},
+	}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+
+	router := gin.New()
+
+	Server(router.Group("/"))
+
+	send := func(method string, endpoint string, headers map[string]string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, endpoint, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Add(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	join := func(password string) *httptest.ResponseRecorder {
+		return send("POST", "/session/join", nil, fmt.Sprintf(`{"session":%q,"password":%q}`, sessionCode, password))
+	}
+	makeRequest := func(listenerToken string) int {
+		mr.Del("requestLimit:" + sessionCode + ":" + fmt.Sprintf("%x", hashID("test")))
+		body := `{"session":"exist","provider":"notaprovider","token":"not.a.token","request":"spotify:track:4uLU6hMCjMI75M1A2tKUQC"}`
+		return send("POST", "/session/request", map[string]string{"X-Listener-Token": listenerToken}, body).Code
+	}
+
+	t.Run("public session", func(t *testing.T) {
+		if w := join("password"); w.Code != 400 {
+			t.Errorf("join on a public session returned %v, expected %v", w.Code, 400)
+		}
+		if code := makeRequest(""); code != 200 {
+			t.Errorf("makeRequest on a public session returned %v, expected %v", code, 200)
+		}
+	})
+
+	if w := send("POST", "/session/config", map[string]string{"X-Session-Token": hostToken}, `{"requestInterval":10,"password":"hunter2"}`); w.Code != 200 {
+		t.Fatalf("setConfig returned %v, expected %v", w.Code, 200)
+	}
+
+	t.Run("password is hashed", func(t *testing.T) {
+		hash := mr.HGet("session:"+sessionCode+":config", "Password")
+		if hash == "hunter2" || bcrypt.CompareHashAndPassword([]byte(hash), []byte("hunter2")) != nil {
+			t.Errorf("setConfig stored password as %q", hash)
+		}
+
+		w := send("GET", "/session/config?session="+sessionCode, nil, "")
+		if w.Body.String() != `{"duplicatePolicy":"merge","duplicateWindow":0,"private":true,"requestInterval":10}` {
+			t.Errorf("getConfig returned %v", w.Body.String())
+		}
+	})
+
+	t.Run("private session without token", func(t *testing.T) {
+		if code := makeRequest(""); code != 401 {
+			t.Errorf("makeRequest returned %v, expected %v", code, 401)
+		}
+		if w := send("GET", "/session/state?session="+sessionCode, nil, ""); w.Code != 401 {
+			t.Errorf("getState returned %v, expected %v", w.Code, 401)
+		}
+		if w := send("GET", "/session/events?session="+sessionCode, nil, ""); w.Code != 401 {
+			t.Errorf("subscribeEvents returned %v, expected %v", w.Code, 401)
+		}
+		if code := makeRequest(hostToken); code != 403 {
+			t.Errorf("makeRequest with host token returned %v, expected %v", code, 403)
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		if w := join("hunter3"); w.Code != 403 {
+			t.Errorf("join returned %v, expected %v", w.Code, 403)
+		}
+	})
+
+	var listenerToken string
+	t.Run("join", func(t *testing.T) {
+		w := join("hunter2")
+		if w.Code != 200 {
+			t.Fatalf("join returned %v, expected %v", w.Code, 200)
+		}
+
+		var res struct {
+			Token     string  `json:"token"`
+			ExpiresIn float64 `json:"expiresIn"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		if res.ExpiresIn != listenerTokenTTL.Seconds() {
+			t.Errorf("join returned expiresIn %v, expected %v", res.ExpiresIn, listenerTokenTTL.Seconds())
+		}
+		listenerToken = res.Token
+
+		if code := makeRequest(listenerToken); code != 200 {
+			t.Errorf("makeRequest with listener token returned %v, expected %v", code, 200)
+		}
+		if w := send("GET", "/session/state?session="+sessionCode, map[string]string{"X-Listener-Token": listenerToken}, ""); w.Code != 404 {
+			t.Errorf("getState with listener token returned %v, expected %v", w.Code, 404)
+		}
+	})
+
+	t.Run("listener token isn't a host token", func(t *testing.T) {
+		if w := send("POST", "/session/update", map[string]string{"X-Session-Token": listenerToken}, testUpdate("spotify:track:a")); w.Code != 403 {
+			t.Errorf("postUpdate with listener token returned %v, expected %v", w.Code, 403)
+		}
+		if w := send("POST", "/session/refresh", map[string]string{"X-Session-Token": listenerToken}, `{"refreshToken":"abc"}`); w.Code != 400 {
+			t.Errorf("refreshSession with listener token returned %v, expected %v", w.Code, 400)
+		}
+	})
+
+	setConfig := func(body string) {
+		if w := send("POST", "/session/config", map[string]string{"X-Session-Token": hostToken}, body); w.Code != 200 {
+			t.Fatalf("setConfig returned %v, expected %v", w.Code, 200)
+		}
+	}
+
+	t.Run("config without password stays private", func(t *testing.T) {
+		setConfig(`{"requestInterval":20}`)
+		if code := makeRequest(""); code != 401 {
+			t.Errorf("makeRequest after a config change returned %v, expected %v", code, 401)
+		}
+		if code := makeRequest(listenerToken); code != 200 {
+			t.Errorf("makeRequest with listener token after a config change returned %v, expected %v", code, 200)
+		}
+	})
+
+	t.Run("password change", func(t *testing.T) {
+		setConfig(`{"requestInterval":20,"password":"hunter3"}`)
+		if code := makeRequest(listenerToken); code != 401 {
+			t.Errorf("makeRequest with a token for the old password returned %v, expected %v", code, 401)
+		}
+
+		var res struct {
+			Token string `json:"token"`
+		}
+		json.Unmarshal(join("hunter3").Body.Bytes(), &res)
+		if code := makeRequest(res.Token); code != 200 {
+			t.Errorf("makeRequest with a token for the new password returned %v, expected %v", code, 200)
+		}
+	})
+
+	t.Run("empty password", func(t *testing.T) {
+		setConfig(`{"requestInterval":20,"password":""}`)
+		if code := makeRequest(""); code != 200 {
+			t.Errorf("makeRequest after removing the password returned %v, expected %v", code, 200)
+		}
+		setConfig(`{"requestInterval":20,"password":"hunter2"}`)
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		var code int
+		for i := 0; i < joinAttempts; i++ {
+			code = join("hunter3").Code
+		}
+		if code != 429 {
+			t.Errorf("join returned %v after %v attempts, expected %v", code, joinAttempts+3, 429)
+		}
+
+		headers := map[string]string{"X-Forwarded-For": "203.0.113.7"}
+		if w := send("POST", "/session/join", headers, fmt.Sprintf(`{"session":%q,"password":"hunter3"}`, sessionCode)); w.Code != 429 {
+			t.Errorf("join with a forwarded address returned %v, expected %v", w.Code, 429)
+		}
+	})
+
+	t.Run("rate limited per session", func(t *testing.T) {
+		mr.Del("joinLimit:" + sessionCode)
+
+		var code int
+		for i := 0; i <= sessionJoinAttempts; i++ {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/session/join", strings.NewReader(fmt.Sprintf(`{"session":%q,"password":"hunter3"}`, sessionCode)))
+			req.RemoteAddr = fmt.Sprintf("198.51.100.%v:1234", i)
+			router.ServeHTTP(w, req)
+			code = w.Code
+		}
+		if code != 429 {
+			t.Errorf("join returned %v after %v attempts from different addresses, expected %v", code, sessionJoinAttempts+1, 429)
+		}
+	})
+}
@@ -0,0 +1,19 @@
+package pogifyapi
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// jwks publishes the public keys session tokens are verified with so other
+// services can verify host tokens without JWT_SECRET
+func (s *server) jwks(c *gin.Context) {
+	keys := make([]jsonWebKey, 0, len(s.jwt.keys))
+	for _, key := range s.jwt.keys {
+		keys = append(keys, key.jwk())
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(200, gin.H{
+		"keys": keys,
+	})
+}
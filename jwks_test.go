@@ -0,0 +1,87 @@
+package pogifyapi
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_server_jwks(t *testing.T) {
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+
+	get := func(router *gin.Engine) []jsonWebKey {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != 200 {
+			t.Fatalf("jwks returned %v, expected %v", w.Code, 200)
+		}
+
+		var set struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+			t.Fatalf("jwks returned invalid json: %v", err)
+		}
+		return set.Keys
+	}
+
+	t.Run("without keys", func(t *testing.T) {
+		router := gin.New()
+		Server(router.Group("/"))
+
+		if keys := get(router); len(keys) != 0 {
+			t.Errorf("jwks returned %v, expected no keys", keys)
+		}
+	})
+
+	t.Run("with keys", func(t *testing.T) {
+		public, private, _ := ed25519.GenerateKey(rand.Reader)
+		privatePath, _ := writeKeys(t, "ed25519", private, public)
+		os.Setenv("JWT_PRIVATE_KEY", privatePath)
+		defer os.Unsetenv("JWT_PRIVATE_KEY")
+
+		router := gin.New()
+		Server(router.Group("/"))
+
+		keys := get(router)
+		if len(keys) != 1 {
+			t.Fatalf("jwks returned %v keys, expected 1", len(keys))
+		}
+		key := keys[0]
+		if key.Kty != "OKP" || key.Crv != "Ed25519" || key.Alg != "EdDSA" || key.Use != "sig" {
+			t.Errorf("jwks returned unexpected key %+v", key)
+		}
+		if key.Kid != thumbprint(key) {
+			t.Errorf("jwks returned kid %v, expected the key's thumbprint", key.Kid)
+		}
+	})
+	t.Run("at the host root", func(t *testing.T) {
+		public, private, _ := ed25519.GenerateKey(rand.Reader)
+		privatePath, _ := writeKeys(t, "ed25519", private, public)
+		os.Setenv("JWT_PRIVATE_KEY", privatePath)
+		defer os.Unsetenv("JWT_PRIVATE_KEY")
+
+		router := gin.New()
+		Server(router.Group("/v2"))
+		WellKnown(router.Group("/"))
+
+		keys := get(router)
+		if len(keys) != 1 || keys[0].Kid != thumbprint(keys[0]) {
+			t.Errorf("jwks at the host root returned %+v, expected the signing key", keys)
+		}
+	})
+}
@@ -0,0 +1,282 @@
+package pogifyapi
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// minRSABits is the smallest RSA key accepted for signing session tokens
+const minRSABits = 2048
+
+// signingMethodEdDSA signs tokens with Ed25519 keys, which jwt-go doesn't
+// support itself
+type signingMethodEdDSA struct{}
+
+var signingMethodEd25519 = new(signingMethodEdDSA)
+
+func init() {
+	jwt.RegisterSigningMethod(signingMethodEd25519.Alg(), func() jwt.SigningMethod {
+		return signingMethodEd25519
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// jwtKey is an asymmetric key session tokens are signed or verified with
+type jwtKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	public  interface{}
+	private interface{}
+}
+
+// newJWTKey wraps an RSA or Ed25519 public key and derives its kid
+func newJWTKey(public interface{}) (*jwtKey, error) {
+	key := &jwtKey{public: public}
+
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key has %v bits, need at least %v", k.N.BitLen(), minRSABits)
+		}
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = signingMethodEd25519
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", public)
+	}
+
+	key.kid = thumbprint(key.jwk())
+	return key, nil
+}
+
+// jsonWebKey is a public key in JSON Web Key form
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+func (k *jwtKey) jwk() jsonWebKey {
+	key := jsonWebKey{
+		Kid: k.kid,
+		Use: "sig",
+		Alg: k.method.Alg(),
+	}
+
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		key.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		key.Kty = "OKP"
+		key.Crv = "Ed25519"
+		key.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+
+	return key
+}
+
+// thumbprint is the RFC 7638 thumbprint of a key
+func thumbprint(key jsonWebKey) string {
+	var canonical string
+	switch key.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, key.E, key.N)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, key.Crv, key.X)
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// readPEM returns the first PEM block in the file at path
+func readPEM(path string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%v: no PEM data", path)
+	}
+	return block, nil
+}
+
+// loadPrivateKey reads a PKCS #8 or PKCS #1 private key from a PEM file
+func loadPrivateKey(path string) (*jwtKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	var public interface{}
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		public = &k.PublicKey
+	case ed25519.PrivateKey:
+		public = k.Public()
+	default:
+		return nil, fmt.Errorf("%v: unsupported key type %T", path, private)
+	}
+
+	key, err := newJWTKey(public)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	key.private = private
+	return key, nil
+}
+
+// loadPublicKey reads a PKIX or PKCS #1 public key from a PEM file
+func loadPublicKey(path string) (*jwtKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var public interface{}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		public, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	key, err := newJWTKey(public)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return key, nil
+}
+
+// loadKeys sets up asymmetric signing with the private key at privatePath.
+// publicPaths is a comma separated list of keys that still verify tokens,
+// like the previous key during rotation.
+func (j *j) loadKeys(privatePath string, publicPaths string) error {
+	signing, err := loadPrivateKey(privatePath)
+	if err != nil {
+		return err
+	}
+
+	j.signing = signing
+	j.keys = map[string]*jwtKey{signing.kid: signing}
+
+	for _, path := range strings.Split(publicPaths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		key, err := loadPublicKey(path)
+		if err != nil {
+			return err
+		}
+		j.keys[key.kid] = key
+	}
+
+	methods := []string{jwt.SigningMethodRS256.Alg(), signingMethodEd25519.Alg()}
+	// keep accepting tokens signed before the switch while JWT_SECRET is set
+	if len(j.secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	j.parser = &jwt.Parser{ValidMethods: methods}
+
+	return nil
+}
+
+// sign signs claims with the private key if one is loaded, or JWT_SECRET
+func (j *j) sign(claims jwt.Claims) (string, error) {
+	if j.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(j.secret)
+	}
+
+	token := jwt.NewWithClaims(j.signing.method, claims)
+	token.Header["kid"] = j.signing.kid
+	return token.SignedString(j.signing.private)
+}
+
+// keyFunc returns the key to verify a session token with
+func (j *j) keyFunc(t *jwt.Token) (interface{}, error) {
+	// the parser only lets HS256 through when JWT_SECRET is in use
+	if t.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+		return j.secret, nil
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	key, ok := j.keys[kid]
+	if !ok {
+		return nil, errors.New("token: kid does not exist")
+	}
+
+	if t.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("token: signing method doesn't match kid")
+	}
+
+	return key.public, nil
+}
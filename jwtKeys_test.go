@@ -0,0 +1,150 @@
+package pogifyapi
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// writeKeys writes key as a PKCS #8 private key and a PKIX public key and
+// returns their paths
+func writeKeys(t *testing.T, name string, key interface{}, public interface{}) (string, string) {
+	dir := t.TempDir()
+
+	private, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error marshalling private key: %v", err)
+	}
+	privatePath := filepath.Join(dir, name+".pem")
+	if err := ioutil.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatalf("Error marshalling public key: %v", err)
+	}
+	publicPath := filepath.Join(dir, name+".pub.pem")
+	if err := ioutil.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return privatePath, publicPath
+}
+
+func Test_j_loadKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPrivate, rsaPublic := writeKeys(t, "rsa", rsaKey, &rsaKey.PublicKey)
+
+	edPublicKey, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPrivate, _ := writeKeys(t, "ed25519", edKey, edPublicKey)
+
+	smallKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	smallPrivate, _ := writeKeys(t, "small", smallKey, &smallKey.PublicKey)
+
+	claims := sessionJwtClaims{
+		Session: "test",
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	}
+
+	parse := func(j *j, token string) error {
+		_, err := j.parser.ParseWithClaims(token, new(sessionJwtClaims), j.keyFunc)
+		return err
+	}
+
+	// the old key signs a token before rotation
+	old := new(j)
+	if err := old.loadKeys(rsaPrivate, ""); err != nil {
+		t.Fatalf("loadKeys errored with: %v", err)
+	}
+	oldToken, err := old.sign(claims)
+	if err != nil {
+		t.Fatalf("sign errored with: %v", err)
+	}
+	if err := parse(old, oldToken); err != nil {
+		t.Errorf("RS256 token didn't verify: %v", err)
+	}
+
+	// rotate to a new key and keep verifying with the old one
+	rotated := new(j)
+	if err := rotated.loadKeys(edPrivate, " "+rsaPublic+" ,"); err != nil {
+		t.Fatalf("loadKeys errored with: %v", err)
+	}
+	if len(rotated.keys) != 2 {
+		t.Errorf("loadKeys loaded %v keys, expected 2", len(rotated.keys))
+	}
+
+	newToken, err := rotated.sign(claims)
+	if err != nil {
+		t.Fatalf("sign errored with: %v", err)
+	}
+	token, err := rotated.parser.ParseWithClaims(newToken, new(sessionJwtClaims), rotated.keyFunc)
+	if err != nil {
+		t.Errorf("EdDSA token didn't verify: %v", err)
+	} else if token.Header["alg"] != "EdDSA" || token.Header["kid"] != rotated.signing.kid {
+		t.Errorf("EdDSA token has header %v", token.Header)
+	}
+	if err := parse(rotated, oldToken); err != nil {
+		t.Errorf("token from the rotated key didn't verify: %v", err)
+	}
+	if err := parse(old, newToken); err == nil {
+		t.Errorf("token from an unknown key verified")
+	}
+
+	// tokens signed with JWT_SECRET only verify while it's set
+	hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	if err := parse(rotated, hmacToken); err == nil {
+		t.Errorf("HS256 token verified without JWT_SECRET")
+	}
+	transition := &j{secret: []byte("secret")}
+	if err := transition.loadKeys(edPrivate, ""); err != nil {
+		t.Fatalf("loadKeys errored with: %v", err)
+	}
+	if err := parse(transition, hmacToken); err != nil {
+		t.Errorf("HS256 token didn't verify with JWT_SECRET: %v", err)
+	}
+
+	// a token can't claim another key's algorithm
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = old.signing.kid
+	forgedToken, _ := forged.SignedString([]byte(rsaPublic))
+	if err := parse(rotated, forgedToken); err == nil {
+		t.Errorf("HS256 token with an RSA kid verified")
+	}
+
+	if err := new(j).loadKeys(smallPrivate, ""); err == nil {
+		t.Errorf("loadKeys accepted a 1024 bit RSA key")
+	}
+	if err := new(j).loadKeys(filepath.Join(t.TempDir(), "missing.pem"), ""); err == nil {
+		t.Errorf("loadKeys accepted a missing key")
+	}
+}
+
+func Test_thumbprint(t *testing.T) {
+	// RFC 7638 section 3.1
+	key := jsonWebKey{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+
+	if got, want := thumbprint(key), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("thumbprint() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package pogifyapi
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	gonanoid "github.com/matoous/go-nanoid"
+)
+
+// providerListener is the makeRequest provider for listener tokens
+const providerListener = "listener"
+
+// listenerIssues is how many anonymous listener tokens a client can get per
+// session per minute when LISTENER_POW is off
+const listenerIssues = 30
+
+type listenerRequest struct {
+	Session string `json:"session" binding:"required"`
+	// Checksum is set by the PoW solution unless LISTENER_POW is off
+	Checksum string `json:"checksum"`
+}
+
+// issueListener mints an anonymous listener token for a public session. The
+// token's subject is the listener's ID for rate limits and bans.
+func (s *server) issueListener(c *gin.Context) {
+	var req listenerRequest
+	// the PoW middleware already read the body
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.Error(err)
+		c.String(400, fmt.Sprint(err))
+		return
+	}
+
+	if s.listenerPoW {
+		// one solution is one listener
+		if fresh, err := s.redis.spendProblem(req.Checksum); err != nil {
+			c.AbortWithError(500, err)
+			return
+		} else if !fresh {
+			c.String(400, "problem already used")
+			return
+		}
+	} else {
+		issued, err := s.redis.rateLimitListener(req.Session, s.clientIP(c))
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+		if issued > listenerIssues {
+			c.Header("retry-after", "60")
+			c.Status(429)
+			return
+		}
+	}
+
+	if exists, err := s.redis.sessionExists(req.Session); err != nil {
+		c.AbortWithError(500, err)
+		return
+	} else if !exists {
+		c.String(404, "no such session")
+		return
+	}
+
+	hash, _, err := s.redis.sessionPassword(req.Session)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	if hash != "" {
+		c.String(403, "private session, join with the password first")
+		return
+	}
+
+	listenerID, err := gonanoid.ID(21)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	token, err := s.newToken(sessionJwtClaims{
+		Session:        req.Session,
+		Role:           roleAnonymous,
+		StandardClaims: jwt.StandardClaims{Subject: listenerID},
+	}, listenerTokenTTL)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"session":   req.Session,
+		"listener":  listenerID,
+		"token":     token,
+		"expiresIn": listenerTokenTTL.Seconds(),
+	})
+}
+
+// identifyListener validates a listener token for sessionID and returns the
+// listener's ID
+func (s *server) identifyListener(sessionID string, token string) (string, error) {
+	claims, err := s.parseSessionToken(token)
+	if err != nil {
+		return "", err
+	}
+	if claims.Session != sessionID || claims.Subject == "" || (claims.Role != roleListener && claims.Role != roleAnonymous) {
+		return "", errors.New("token: not a listener token for this session")
+	}
+
+	if revoked, err := s.tokenRevoked(claims); err != nil {
+		return "", err
+	} else if revoked {
+		return "", errors.New("token revoked")
+	}
+
+	// keep listener IDs apart from provider subjects
+	return providerListener + ":" + claims.Subject, nil
+}
@@ -0,0 +1,154 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_server_issueListener(t *testing.T) {
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+	mr.Set("session:exist", "refresh")
+
+	router := gin.New()
+
+	Server(router.Group("/"))
+
+	issue := func(router *gin.Engine, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/listener", strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+	type listener struct {
+		Listener  string  `json:"listener"`
+		Token     string  `json:"token"`
+		ExpiresIn float64 `json:"expiresIn"`
+	}
+	makeRequest := func(session string, token string) int {
+		w := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"session":%q,"provider":"listener","token":%q,"request":"spotify:track:4uLU6hMCjMI75M1A2tKUQC"}`, session, token)
+		req, _ := http.NewRequest("POST", "/session/request", strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	var l listener
+	t.Run("issue", func(t *testing.T) {
+		w := issue(router, `{"session":"exist"}`)
+		if w.Code != 200 {
+			t.Fatalf("issueListener returned %v, expected %v: %v", w.Code, 200, w.Body.String())
+		}
+		json.Unmarshal(w.Body.Bytes(), &l)
+		if l.Listener == "" || l.Token == "" || l.ExpiresIn != listenerTokenTTL.Seconds() {
+			t.Errorf("issueListener returned %v", w.Body.String())
+		}
+		var claims sessionJwtClaims
+		new(jwt.Parser).ParseUnverified(l.Token, &claims)
+		if claims.Audience != defaultJWTAudience+":"+roleAnonymous {
+			t.Errorf("listener token has audience %v, expected its own", claims.Audience)
+		}
+
+		if w := issue(router, `{}`); w.Code != 400 {
+			t.Errorf("issueListener without session returned %v, expected %v", w.Code, 400)
+		}
+	})
+
+	t.Run("makeRequest as listener", func(t *testing.T) {
+		if code := makeRequest("exist", l.Token); code != 200 {
+			t.Errorf("makeRequest returned %v, expected %v", code, 200)
+		}
+		if !mr.Exists(fmt.Sprintf("requestLimit:exist:%x", hashID(providerListener+":"+l.Listener))) {
+			t.Errorf("makeRequest didn't rate limit by listener ID")
+		}
+		if code := makeRequest("exist", l.Token); code != 429 {
+			t.Errorf("second makeRequest returned %v, expected %v", code, 429)
+		}
+
+		// another listener has its own rate limit
+		var other listener
+		json.Unmarshal(issue(router, `{"session":"exist"}`).Body.Bytes(), &other)
+		if other.Listener == l.Listener {
+			t.Errorf("issueListener returned the same listener ID twice")
+		}
+		if code := makeRequest("exist", other.Token); code != 200 {
+			t.Errorf("makeRequest for another listener returned %v, expected %v", code, 200)
+		}
+
+		if code := makeRequest("other", l.Token); code != 401 {
+			t.Errorf("makeRequest for another session returned %v, expected %v", code, 401)
+		}
+		if code := makeRequest("exist", "not.a.token"); code != 401 {
+			t.Errorf("makeRequest with invalid token returned %v, expected %v", code, 401)
+		}
+	})
+
+	t.Run("unknown session", func(t *testing.T) {
+		if w := issue(router, `{"session":"nope"}`); w.Code != 404 {
+			t.Errorf("issueListener for an unknown session returned %v, expected %v", w.Code, 404)
+		}
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		mr.Set("session:limited", "refresh")
+		code := 0
+		for i := 0; i <= listenerIssues; i++ {
+			code = issue(router, `{"session":"limited"}`).Code
+		}
+		if code != 429 {
+			t.Errorf("issueListener returned %v after %v tokens, expected %v", code, listenerIssues+1, 429)
+		}
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/listener", strings.NewReader(`{"session":"limited"}`))
+		req.Header.Add("X-Forwarded-For", "203.0.113.7")
+		router.ServeHTTP(w, req)
+		if w.Code != 429 {
+			t.Errorf("issueListener with a forwarded address returned %v, expected %v", w.Code, 429)
+		}
+	})
+
+	t.Run("private session", func(t *testing.T) {
+		mr.Set("session:private", "refresh")
+		mr.HSet("session:private:config", "Password", "hash")
+		if w := issue(router, `{"session":"private"}`); w.Code != 403 {
+			t.Errorf("issueListener on a private session returned %v, expected %v", w.Code, 403)
+		}
+	})
+
+	t.Run("proof of work", func(t *testing.T) {
+		// on unless turned off
+		os.Unsetenv("LISTENER_POW")
+		defer os.Setenv("LISTENER_POW", "false")
+
+		router := gin.New()
+		Server(router.Group("/"))
+
+		if w := issue(router, `{"session":"exist"}`); w.Code != 400 {
+			t.Errorf("issueListener without a solution returned %v, expected %v", w.Code, 400)
+		}
+
+		solution := solveProblem(t, router)
+		solution["session"] = "exist"
+		body, _ := json.Marshal(solution)
+		if w := issue(router, string(body)); w.Code != 200 {
+			t.Errorf("issueListener with a solution returned %v, expected %v: %v", w.Code, 200, w.Body.String())
+		}
+		if w := issue(router, string(body)); w.Code != 400 {
+			t.Errorf("issueListener with a used solution returned %v, expected %v", w.Code, 400)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package pogifyapi
+
+import (
+	"sync"
+)
+
+// localBrokerBuffer is how many messages a subscriber can fall behind before
+// messages to it are dropped
+const localBrokerBuffer = 32
+
+// localBroker fans messages out to subscribers connected to this instance
+type localBroker struct {
+	mu       sync.RWMutex
+	channels map[string]map[chan []byte]struct{}
+}
+
+func newLocalBroker(_ *r) (Publisher, error) {
+	return &localBroker{
+		channels: make(map[string]map[chan []byte]struct{}),
+	}, nil
+}
+
+func (b *localBroker) Publish(channel string, data []byte) (int64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var n int64
+	for ch := range b.channels[channel] {
+		select {
+		case ch <- data:
+			n++
+		default:
+			// subscriber isn't keeping up; drop rather than block everyone else
+		}
+	}
+
+	return n, nil
+}
+
+func (b *localBroker) Subscribers(channel string) (int64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return int64(len(b.channels[channel])), nil
+}
+
+func (b *localBroker) Active(channel string) (bool, error) {
+	n, err := b.Subscribers(channel)
+	return n > 0, err
+}
+
+func (b *localBroker) Subscribe(channel string) (*Subscription, error) {
+	ch := make(chan []byte, localBrokerBuffer)
+
+	b.mu.Lock()
+	if b.channels[channel] == nil {
+		b.channels[channel] = make(map[chan []byte]struct{})
+	}
+	b.channels[channel][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return &Subscription{
+		C: ch,
+		unsubscribe: func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			if _, ok := b.channels[channel][ch]; !ok {
+				return
+			}
+			delete(b.channels[channel], ch)
+			if len(b.channels[channel]) == 0 {
+				delete(b.channels, channel)
+			}
+			close(ch)
+		},
+	}, nil
+}
+
+func (b *localBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for channel, subs := range b.channels {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(b.channels, channel)
+	}
+
+	return nil
+}
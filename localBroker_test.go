@@ -0,0 +1,79 @@
+package pogifyapi
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_localBroker(t *testing.T) {
+	p, _ := newLocalBroker(nil)
+	b := p.(*localBroker)
+
+	sub1, _ := b.Subscribe("test")
+	sub2, _ := b.Subscribe("test")
+	other, _ := b.Subscribe("other")
+
+	if n, _ := b.Subscribers("test"); n != 2 {
+		t.Errorf("Subscribers returned %v, expected %v", n, 2)
+	}
+	if active, _ := b.Active("nobody"); active {
+		t.Errorf("Active returned true for a channel without subscribers")
+	}
+
+	n, err := b.Publish("test", []byte("hello"))
+	if err != nil {
+		t.Fatalf("Publish errored with: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Publish delivered to %v, expected %v", n, 2)
+	}
+
+	for _, sub := range []*Subscription{sub1, sub2} {
+		select {
+		case msg := <-sub.C:
+			if string(msg) != "hello" {
+				t.Errorf("subscriber got %v, expected %v", string(msg), "hello")
+			}
+		case <-time.After(time.Second):
+			t.Error("subscriber didn't receive message")
+		}
+	}
+
+	select {
+	case msg := <-other.C:
+		t.Errorf("subscriber on other channel got %v", string(msg))
+	default:
+	}
+
+	sub1.Close()
+	sub1.Close()
+	if n, _ := b.Subscribers("test"); n != 1 {
+		t.Errorf("Subscribers returned %v after Close, expected %v", n, 1)
+	}
+	if _, ok := <-sub1.C; ok {
+		t.Error("closed subscription channel wasn't closed")
+	}
+
+	b.Close()
+	if _, ok := <-sub2.C; ok {
+		t.Error("Close didn't close subscription channels")
+	}
+	// closing after the broker is closed shouldn't panic
+	sub2.Close()
+}
+
+func Test_localBroker_slowSubscriber(t *testing.T) {
+	p, _ := newLocalBroker(nil)
+	b := p.(*localBroker)
+
+	sub, _ := b.Subscribe("test")
+	defer sub.Close()
+
+	for i := 0; i < localBrokerBuffer; i++ {
+		b.Publish("test", []byte("fill"))
+	}
+
+	if n, _ := b.Publish("test", []byte("overflow")); n != 0 {
+		t.Errorf("Publish delivered to a full subscriber")
+	}
+}
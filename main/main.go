@@ -24,6 +24,7 @@ func startServer() *gin.Engine {
 
 	if os.Getenv("V2") != "" {
 		v2.Server(s.Group("/v2"))
+		v2.WellKnown(s.Group("/"))
 	}
 
 	return s
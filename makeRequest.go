@@ -1,13 +1,13 @@
 package pogifyapi
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	gonanoid "github.com/matoous/go-nanoid"
 )
 
 type request struct {
@@ -17,12 +17,6 @@ type request struct {
 	Request  string `json:"request" binding:"required"`
 }
 
-// var rx = /^.*(?:(?:youtu\.be\/|v\/|vi\/|u\/\w\/|embed\/)|(?:(?:watch)?\?v(?:i)?=|\&v(?:i)?=))([^#\&\?]*).*/;
-
-func (r *request) validateRequest() {
-	// r.Request
-}
-
 func (s *server) makeRequest(c *gin.Context) {
 	var r request
 
@@ -34,37 +28,15 @@ func (s *server) makeRequest(c *gin.Context) {
 		return
 	}
 
-	// var for identifier
-	var id string
-	// validate token against provider
-	switch r.Provider {
-	case "twitch":
-		// validate token with twitch
-		// s.auth.getTwitchKeys()
-		token, err := s.auth.ValidateTwitchToken(r.Token)
-		if err != nil {
-			c.Error(err)
-			c.String(401, fmt.Sprint(err))
-			return
-		}
-		id = token.Claims.(jwt.MapClaims)["sub"].(string)
-	case "google":
-		// validate token with google
-		s.auth.getGooglePEM()
-		token, err := s.auth.ValidateGoogleToken(r.Token)
-		if err != nil {
-			c.Error(err)
-			c.String(401, fmt.Sprint(err))
-			return
-		}
-		id = token.Claims.(jwt.MapClaims)["sub"].(string)
-	default:
-		if _testing {
-			id = "test"
-		} else {
-			c.String(400, "invalid provider")
-			return
-		}
+	song, err := parseSongRequest(r.Request)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	id, ok := s.identifyRequester(c, r.Session, r.Provider, r.Token)
+	if !ok {
+		return
 	}
 
 	// eager increment rate limit
@@ -81,29 +53,102 @@ func (s *server) makeRequest(c *gin.Context) {
 	}
 
 	// check active session
-	res, err := http.Get(fmt.Sprintf("%v/channels-stats?id=%v", s.pubsub.url, r.Session))
+	active, err := s.pubsub.Active(r.Session)
 	if err != nil {
 		go s.redis.reverseRateLimit(r.Session, id)
 		c.AbortWithError(500, err)
 		return
 	}
 
-	if res.StatusCode == 404 {
+	if !active {
 		c.String(404, "inactive session")
 		return
 	}
 
-	tA := time.Now()
-	ch := make(chan *http.Response)
-	errCh := make(chan error)
-
-	go s.pubsub.pub(ch, errCh, "host_"+r.Session, []byte(r.Request))
+	requestID, err := gonanoid.ID(12)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	queued := queuedRequest{
+		ID:        requestID,
+		Song:      *song,
+		Requester: requesterID(r.Provider, id),
+		Time:      time.Now().UnixNano() / int64(time.Millisecond),
+		Status:    requestPending,
+		Count:     1,
+	}
+	duplicate, original, err := s.redis.queueRequest(r.Session, queued)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	switch duplicate {
+	case duplicateReject:
+		c.JSON(409, gin.H{
+			"error":     "duplicate_request",
+			"message":   "song was already requested",
+			"requester": original.Requester,
+			"request":   original,
+		})
+		return
+	case duplicateMerge:
+		// the host sees the original again with its new count
+		queued = *original
+	}
 
-	select {
-	case <-ch:
-	case err = <-errCh:
+	queuedJSON, err := json.Marshal(queued)
+	if err != nil {
 		c.AbortWithError(500, err)
+		return
+	}
 
+	// the request is queued so a host that misses it can still list it
+	tA := time.Now()
+	if _, err = s.pubsub.Publish("host_"+r.Session, queuedJSON); err != nil {
+		c.Error(err)
 	}
 	log.Print(time.Now().Sub(tA))
+
+	c.JSON(200, queued)
+}
+
+// identifyRequester checks the listener is allowed in sessionID, validates
+// their token against provider and checks they aren't banned. It returns the
+// listener's ID, or responds and returns false.
+func (s *server) identifyRequester(c *gin.Context, sessionID string, provider string, token string) (string, bool) {
+	listenerToken := c.GetHeader("X-Listener-Token")
+	if listenerToken == "" && provider == providerListener {
+		listenerToken = token
+	}
+	if !s.authorizeListener(c, sessionID, listenerToken) {
+		return "", false
+	}
+
+	var id string
+	var err error
+	if provider == providerListener {
+		id, err = s.identifyListener(sessionID, token)
+	} else {
+		id, err = s.identify(provider, token)
+	}
+	if err == errInvalidProvider {
+		c.String(400, "invalid provider")
+		return "", false
+	}
+	if err != nil {
+		c.Error(err)
+		c.String(401, fmt.Sprint(err))
+		return "", false
+	}
+
+	if banned, err := s.redis.requesterBanned(sessionID, requesterID(provider, id)); err != nil {
+		c.AbortWithError(500, err)
+		return "", false
+	} else if banned {
+		c.String(403, "banned from this session")
+		return "", false
+	}
+
+	return id, true
 }
@@ -42,12 +42,29 @@ func Test_server_makeRequest(t *testing.T) {
 			t.Errorf("invalid body to makeRequest didn't return 400, but %v", w.Code)
 		}
 	})
+	t.Run("unsupported request", func(t *testing.T) {
+		invalidBody := request{
+			Session:  "exist",
+			Provider: "notaprovider",
+			Token:    "not.a.token",
+			Request:  "not a request",
+		}
+		invalidBodyBytes, _ := json.Marshal(invalidBody)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/request", bytes.NewReader(invalidBodyBytes))
+		router.ServeHTTP(w, req)
+
+		if w.Code != 400 || w.Body.String() != errUnsupportedRequest.Error() {
+			t.Errorf("unsupported request to makeRequest returned %v: %v", w.Code, w.Body.String())
+		}
+	})
 	t.Run("valid request, inactive session", func(t *testing.T) {
 		validBody := request{
 			Session:  "notexist",
 			Provider: "notaprovider",
 			Token:    "not.a.token",
-			Request:  "not a request",
+			Request:  "https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC?si=abc",
 		}
 		validBodyBytes, _ := json.Marshal(validBody)
 
@@ -66,7 +83,7 @@ func Test_server_makeRequest(t *testing.T) {
 			Session:  "exist",
 			Provider: "notaprovider",
 			Token:    "not.a.token",
-			Request:  "not a request",
+			Request:  "https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC?si=abc",
 		}
 		validBodyBytes, _ := json.Marshal(validBody)
 
@@ -85,7 +102,7 @@ func Test_server_makeRequest(t *testing.T) {
 			Session:  "exist",
 			Provider: "notaprovider",
 			Token:    "not.a.token",
-			Request:  "not a request",
+			Request:  "https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC?si=abc",
 		}
 		validBodyBytes, _ := json.Marshal(validBody)
 
@@ -0,0 +1,58 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+var errNotObject = errors.New("merge patch: documents must be JSON objects")
+
+// createMergePatch returns a JSON merge patch (RFC 7386) that turns original
+// into modified. Both documents must be JSON objects.
+func createMergePatch(original []byte, modified []byte) ([]byte, error) {
+	var o, m map[string]interface{}
+	if err := json.Unmarshal(original, &o); err != nil || o == nil {
+		return nil, errNotObject
+	}
+	if err := json.Unmarshal(modified, &m); err != nil || m == nil {
+		return nil, errNotObject
+	}
+
+	return json.Marshal(diffObjects(o, m))
+}
+
+func diffObjects(o map[string]interface{}, m map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{})
+
+	for k, mv := range m {
+		ov, ok := o[k]
+		if !ok {
+			patch[k] = mv
+			continue
+		}
+
+		oObj, oIsObj := ov.(map[string]interface{})
+		mObj, mIsObj := mv.(map[string]interface{})
+		if oIsObj && mIsObj {
+			if d := diffObjects(oObj, mObj); len(d) > 0 {
+				patch[k] = d
+			}
+			continue
+		}
+
+		// arrays and scalars are replaced whole
+		if !reflect.DeepEqual(ov, mv) {
+			patch[k] = mv
+		}
+	}
+
+	// null removes a member
+	for k := range o {
+		if _, ok := m[k]; !ok {
+			patch[k] = nil
+		}
+	}
+
+	return patch
+}
@@ -0,0 +1,46 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func Test_createMergePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		modified string
+		want     string
+		wantErr  bool
+	}{
+		{"unchanged", `{"a":1}`, `{"a":1}`, `{}`, false},
+		{"changed scalar", `{"a":1,"b":"x"}`, `{"a":2,"b":"x"}`, `{"a":2}`, false},
+		{"added member", `{"a":1}`, `{"a":1,"b":true}`, `{"b":true}`, false},
+		{"removed member", `{"a":1,"b":true}`, `{"a":1}`, `{"b":null}`, false},
+		{"nested object", `{"a":{"b":1,"c":2}}`, `{"a":{"b":1,"c":3}}`, `{"a":{"c":3}}`, false},
+		{"array replaced", `{"a":[1,2]}`, `{"a":[1,3]}`, `{"a":[1,3]}`, false},
+		{"object to scalar", `{"a":{"b":1}}`, `{"a":1}`, `{"a":1}`, false},
+		{"original not object", `[1]`, `{"a":1}`, ``, true},
+		{"modified not object", `{"a":1}`, `"a"`, ``, true},
+		{"invalid json", `{"a":1}`, `not json`, ``, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := createMergePatch([]byte(tt.original), []byte(tt.modified))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("createMergePatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var gotV, wantV interface{}
+			json.Unmarshal(got, &gotV)
+			json.Unmarshal([]byte(tt.want), &wantV)
+			if !reflect.DeepEqual(gotV, wantV) {
+				t.Errorf("createMergePatch() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package pogifyapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// nchan publishes to an nchan server over HTTP
+type nchan struct {
+	secret string
+	url    string
+	client *http.Client
+}
+
+type nchanChannelInfo struct {
+	Messages    int64 `json:"messages"`
+	Subscribers int64 `json:"subscribers"`
+}
+
+func newNchan(_ *r) (Publisher, error) {
+	url := os.Getenv("PUBSUB_URL")
+	if url == "" {
+		return nil, errors.New("PUBSUB_URL missing in .env. Add it and restart the server")
+	}
+
+	return &nchan{
+		secret: os.Getenv("PUBSUB_SECRET"),
+		url:    url,
+		client: new(http.Client),
+	}, nil
+}
+
+func (p *nchan) Publish(channel string, data []byte) (int64, error) {
+	pub, err := http.NewRequest("POST", p.url+"/pub", bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	pub.Header.Add("authorization", p.secret)
+	pub.Header.Add("accept", "application/json")
+	pubQ := pub.URL.Query()
+	pubQ.Add("id", channel)
+	pub.URL.RawQuery = pubQ.Encode()
+
+	res, err := p.client.Do(pub)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode > 399 {
+		return 0, fmt.Errorf("pubsub error with: %v", res.StatusCode)
+	}
+
+	var info nchanChannelInfo
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return 0, err
+	}
+
+	return info.Subscribers, nil
+}
+
+func (p *nchan) Subscribers(channel string) (int64, error) {
+	info, err := p.channelInfo(channel)
+	if err != nil || info == nil {
+		return 0, err
+	}
+
+	return info.Subscribers, nil
+}
+
+func (p *nchan) Active(channel string) (bool, error) {
+	info, err := p.channelInfo(channel)
+	return info != nil, err
+}
+
+// channelInfo returns the stats of channel, or nil if nchan doesn't know it
+func (p *nchan) channelInfo(channel string) (*nchanChannelInfo, error) {
+	stats, err := http.NewRequest("GET", p.url+"/channels-stats", nil)
+	if err != nil {
+		return nil, err
+	}
+	stats.Header.Add("accept", "application/json")
+	statsQ := stats.URL.Query()
+	statsQ.Add("id", channel)
+	stats.URL.RawQuery = statsQ.Encode()
+
+	res, err := p.client.Do(stats)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// nchan 404s on channels nobody has published or subscribed to
+	if res.StatusCode == 404 {
+		return nil, nil
+	}
+
+	if res.StatusCode > 399 {
+		return nil, fmt.Errorf("pubsub error with: %v", res.StatusCode)
+	}
+
+	info := new(nchanChannelInfo)
+	if err := json.NewDecoder(res.Body).Decode(info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+func (p *nchan) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
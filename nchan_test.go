@@ -0,0 +1,155 @@
+package pogifyapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	gonanoid "github.com/matoous/go-nanoid"
+)
+
+var _pubsubsecret = "secret"
+
+func mockNchan() *httptest.Server {
+	mockPubSubHandler := gin.New()
+
+	mockPubSubHandler.POST("/pub", func(c *gin.Context) {
+		if c.GetHeader("authorization") != _pubsubsecret {
+			c.Status(401)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"messages":    1,
+			"subscribers": 2,
+		})
+	})
+
+	mockPubSubHandler.GET("/channels-stats", func(c *gin.Context) {
+		switch id := c.Query("id"); id {
+		case "exist":
+			c.JSON(200, gin.H{
+				"messages":    1,
+				"subscribers": 2,
+			})
+		case "idle":
+			c.JSON(200, gin.H{
+				"messages":    1,
+				"subscribers": 0,
+			})
+		case "notexist":
+			c.Status(404)
+		}
+	})
+
+	return httptest.NewServer(mockPubSubHandler)
+}
+
+func Test_newNchan(t *testing.T) {
+	url := os.Getenv("PUBSUB_URL")
+	defer os.Setenv("PUBSUB_URL", url)
+
+	os.Setenv("PUBSUB_URL", "")
+	if _, err := newNchan(nil); err == nil {
+		t.Error("newNchan didn't error without PUBSUB_URL")
+	}
+
+	os.Setenv("PUBSUB_URL", "http://localhost")
+	if _, err := newNchan(nil); err != nil {
+		t.Errorf("newNchan errored with: %v", err)
+	}
+}
+
+func Test_nchan_Publish(t *testing.T) {
+	mockServer := mockNchan()
+	defer mockServer.Close()
+
+	p := &nchan{
+		url:    mockServer.URL,
+		client: new(http.Client),
+	}
+
+	testChannel, _ := gonanoid.ID(10)
+	testData, _ := gonanoid.ID(20)
+
+	t.Run("missing auth header", func(t *testing.T) {
+		if _, err := p.Publish(testChannel, []byte(testData)); err == nil {
+			t.Error("Publish didn't error on unauthorized publish")
+		}
+	})
+
+	t.Run("with auth header", func(t *testing.T) {
+		p.secret = _pubsubsecret
+		n, err := p.Publish(testChannel, []byte(testData))
+		if err != nil {
+			t.Fatalf("Publish errored with: %v", err)
+		}
+
+		if n != 2 {
+			t.Errorf("Publish returned %v subscribers, expected %v", n, 2)
+		}
+	})
+}
+
+func Test_nchan_Subscribers(t *testing.T) {
+	mockServer := mockNchan()
+	defer mockServer.Close()
+
+	p := &nchan{
+		url:    mockServer.URL,
+		client: new(http.Client),
+	}
+
+	tests := []struct {
+		channel string
+		want    int64
+	}{
+		{"exist", 2},
+		{"idle", 0},
+		{"notexist", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			got, err := p.Subscribers(tt.channel)
+			if err != nil {
+				t.Fatalf("Subscribers errored with: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Subscribers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nchan_Active(t *testing.T) {
+	mockServer := mockNchan()
+	defer mockServer.Close()
+
+	p := &nchan{
+		url:    mockServer.URL,
+		client: new(http.Client),
+	}
+
+	tests := []struct {
+		channel string
+		want    bool
+	}{
+		{"exist", true},
+		// nchan keeps channels with messages after their subscribers leave
+		{"idle", true},
+		{"notexist", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			got, err := p.Active(tt.channel)
+			if err != nil {
+				t.Fatalf("Active errored with: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Active() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
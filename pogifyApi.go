@@ -3,10 +3,12 @@ package pogifyapi
 import (
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-redis/redis/v8"
@@ -17,10 +19,22 @@ import (
 var _testing = false
 
 func init() {
-	if os.Getenv("JWT_SECRET") == "" {
+	if os.Getenv("JWT_PRIVATE_KEY") != "" {
+		if os.Getenv("JWT_SECRET") != "" {
+			log.Println("JWT_SECRET and JWT_PRIVATE_KEY both set. Server will still accept tokens signed with JWT_SECRET; remove it once they've expired")
+		}
+	} else if os.Getenv("JWT_SECRET") == "" {
 		log.Println("JWT_SECRET missing in .env. Server will use empty string as secret")
 	}
 
+	if os.Getenv("JWT_ISSUER") == "" {
+		log.Printf("JWT_ISSUER missing in .env. Server will use %v", defaultJWTIssuer)
+	}
+
+	if os.Getenv("JWT_AUDIENCE") == "" {
+		log.Printf("JWT_AUDIENCE missing in .env. Server will use %v", defaultJWTAudience)
+	}
+
 	if os.Getenv("REDIS_URI") == "" {
 		log.Println("REDIS_URI missing in .env. Server will use localhost:6379 instead")
 	}
@@ -33,12 +47,46 @@ func init() {
 		log.Println("PUBSUB_SECRET missing in .env. Server will use empty string as secret")
 	}
 
-	if os.Getenv("PUBSUB_URL") == "" {
+	if os.Getenv("PUBSUB_DRIVER") == "" {
+		log.Println("PUBSUB_DRIVER missing in .env. Server will use nchan")
+	}
+
+	if d := os.Getenv("PUBSUB_DRIVER"); (d == "" || d == "nchan") && os.Getenv("PUBSUB_URL") == "" {
 		if !_testing {
 			panic("PUBSUB_URL missing in .env. Add it and restart the server.")
 		}
 	}
 
+	if os.Getenv("RAW_UPDATES") != "" {
+		if _, err := strconv.ParseBool(os.Getenv("RAW_UPDATES")); err != nil {
+			log.Println("Can't parse RAW_UPDATES to bool, server will validate updates")
+		}
+	}
+
+	if os.Getenv("ALLOW_REFRESH_QUERY") != "" {
+		if _, err := strconv.ParseBool(os.Getenv("ALLOW_REFRESH_QUERY")); err != nil {
+			log.Println("Can't parse ALLOW_REFRESH_QUERY to bool, server will still accept refresh tokens in the query string")
+		}
+	}
+
+	if os.Getenv("LISTENER_POW") != "" {
+		if _, err := strconv.ParseBool(os.Getenv("LISTENER_POW")); err != nil {
+			log.Println("Can't parse LISTENER_POW to bool, server will require proof of work for listener tokens")
+		}
+	}
+
+	if os.Getenv("TRUSTED_PROXIES") == "" {
+		log.Println("TRUSTED_PROXIES missing in .env. Server will rate limit clients by their connecting address")
+	} else if _, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		log.Println("Can't parse TRUSTED_PROXIES, server will rate limit clients by their connecting address")
+	}
+
+	if os.Getenv("SESSION_TOKEN_TTL") == "" {
+		log.Println("SESSION_TOKEN_TTL missing in .env. Server will use 1 hour.")
+	} else if _, err := strconv.Atoi(os.Getenv("SESSION_TOKEN_TTL")); err != nil {
+		log.Println("Can't parse SESSION_TOKEN_TTL to int, server will use 1 hour")
+	}
+
 	if os.Getenv("POW_SECRET") == "" {
 		log.Println("POW_SECRET missing in .env. Server will use random string as secret")
 	}
@@ -55,21 +103,62 @@ func init() {
 
 type server struct {
 	redis  *r
-	pubsub *pubsub
+	pubsub Publisher
 	jwt    *j
 	auth   *auth
 	pow    *ginpow.Middleware
+
+	// rawUpdates skips validating updates for older clients
+	rawUpdates bool
+	// refreshQuery still accepts refresh tokens in the query string
+	refreshQuery bool
+	// listenerPoW requires a solved problem for anonymous listener tokens
+	listenerPoW bool
+	// privateSessions is off for drivers whose listeners subscribe to the
+	// broker directly, where the server can't check their tokens
+	privateSessions bool
+	// trustedProxies are believed about the client's address in
+	// X-Forwarded-For
+	trustedProxies []*net.IPNet
 }
 
 func (s *server) cors(c *gin.Context) {
 	c.Header("Access-Control-Allow-Origin", "*")
-	c.Header("Access-Control-Allow-Methods", "POST")
-	c.Header("Access-Control-Allow-Headers", "X-Session-Token,Content-Type")
+	c.Header("Access-Control-Allow-Methods", "GET,POST,DELETE")
+	c.Header("Access-Control-Allow-Headers", "X-Session-Token,X-Listener-Token,Content-Type,Last-Event-ID")
+	c.Header("Access-Control-Expose-Headers", "Deprecation")
 	c.Header("Access-Control-Max-Age", "7200")
 }
 
+// jsonError responds with a machine readable error code and a message
+func jsonError(c *gin.Context, code int, err string, message string) {
+	c.JSON(code, gin.H{
+		"error":   err,
+		"message": message,
+	})
+}
+
+const (
+	defaultJWTIssuer   = "pogify"
+	defaultJWTAudience = "pogify-api/v2"
+)
+
 type j struct {
 	secret []byte
+	parser *jwt.Parser
+
+	// issuer and audience are set on and required of session tokens so
+	// other API versions' tokens aren't accepted
+	issuer   string
+	audience string
+
+	// ttl is how long session tokens are valid for
+	ttl time.Duration
+
+	// signing is the private key tokens are signed with instead of secret
+	signing *jwtKey
+	// keys verifies asymmetrically signed tokens by kid
+	keys map[string]*jwtKey
 }
 
 // Server sets routes for api
@@ -97,14 +186,29 @@ func Server(rr *gin.RouterGroup) {
 
 	s.redis = r
 
-	var p = new(pubsub)
-	p.secret = os.Getenv("PUBSUB_SECRET")
-	p.url = os.Getenv("PUBSUB_URL")
-	s.pubsub = p
+	driver := os.Getenv("PUBSUB_DRIVER")
+	s.pubsub, err = newPublisher(driver, r)
+	if err != nil {
+		panic(err)
+	}
+	s.privateSessions = driver != "" && driver != "nchan"
 
-	var j = new(j)
-	j.secret = []byte(os.Getenv("JWT_SECRET"))
-	s.jwt = j
+	s.rawUpdates, _ = strconv.ParseBool(os.Getenv("RAW_UPDATES"))
+
+	s.refreshQuery = true
+	if v, err := strconv.ParseBool(os.Getenv("ALLOW_REFRESH_QUERY")); err == nil {
+		s.refreshQuery = v
+	}
+
+	// anonymous listeners are free to create without it
+	s.listenerPoW = true
+	if v, err := strconv.ParseBool(os.Getenv("LISTENER_POW")); err == nil {
+		s.listenerPoW = v
+	}
+
+	s.trustedProxies, _ = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+
+	s.jwt = newJWT()
 
 	var a = new(auth)
 	go a.getGooglePEM()
@@ -137,20 +241,116 @@ func Server(rr *gin.RouterGroup) {
 		sessionEndpoints.OPTIONS("/claim", s.cors)
 		sessionEndpoints.POST("/claim", s.pow.VerifyNonceMiddleware, s.claimSession)
 
+		sessionEndpoints.OPTIONS("/vanity", s.cors)
+		sessionEndpoints.POST("/vanity", s.reserveVanity)
+		sessionEndpoints.DELETE("/vanity", s.releaseVanity)
+
+		sessionEndpoints.OPTIONS("/vanity/claim", s.cors)
+		sessionEndpoints.POST("/vanity/claim", s.claimVanity)
+
 		sessionEndpoints.OPTIONS("/refresh", s.cors)
 		sessionEndpoints.POST("/refresh", s.refreshSession)
 
+		sessionEndpoints.OPTIONS("/end", s.cors)
+		sessionEndpoints.POST("/end", s.requireSession, s.endSession)
+
+		sessionEndpoints.OPTIONS("/revoke", s.cors)
+		sessionEndpoints.POST("/revoke", s.requireSession, s.revokeToken)
+
+		sessionEndpoints.OPTIONS("/delegates", s.cors)
+		sessionEndpoints.POST("/delegates", s.requireSession, s.addDelegate)
+		sessionEndpoints.GET("/delegates", s.requireSession, s.getDelegates)
+		sessionEndpoints.DELETE("/delegates", s.requireSession, s.removeDelegate)
+
+		sessionEndpoints.OPTIONS("/join", s.cors)
+		sessionEndpoints.POST("/join", s.join)
+
+		sessionEndpoints.OPTIONS("/listener", s.cors)
+		if s.listenerPoW {
+			sessionEndpoints.POST("/listener", s.pow.VerifyNonceMiddleware, s.issueListener)
+		} else {
+			sessionEndpoints.POST("/listener", s.issueListener)
+		}
+
 		sessionEndpoints.OPTIONS("/update", s.cors)
-		sessionEndpoints.POST("/update", s.postUpdate)
+		sessionEndpoints.POST("/update", s.requireScope(scopeUpdate), s.postUpdate)
 
 		sessionEndpoints.OPTIONS("/request", s.cors)
 		sessionEndpoints.POST("/request", s.makeRequest)
 
+		sessionEndpoints.OPTIONS("/requests", s.cors)
+		sessionEndpoints.GET("/requests", s.requireScope(scopeRequests), s.getRequests)
+		sessionEndpoints.DELETE("/requests", s.requireScope(scopeRequests), s.clearRequests)
+		sessionEndpoints.OPTIONS("/requests/ranked", s.cors)
+		sessionEndpoints.GET("/requests/ranked", s.getRankedRequests)
+		sessionEndpoints.OPTIONS("/requests/vote", s.cors)
+		sessionEndpoints.POST("/requests/vote", s.voteOnRequest)
+		sessionEndpoints.OPTIONS("/requests/accept", s.cors)
+		sessionEndpoints.POST("/requests/accept", s.requireScope(scopeRequests), s.acceptRequest)
+		sessionEndpoints.OPTIONS("/requests/reject", s.cors)
+		sessionEndpoints.POST("/requests/reject", s.requireScope(scopeRequests), s.rejectRequest)
+
+		sessionEndpoints.OPTIONS("/bans", s.cors)
+		sessionEndpoints.POST("/bans", s.requireScope(scopeRequests), s.banRequester)
+		sessionEndpoints.GET("/bans", s.requireScope(scopeRequests), s.getBans)
+		sessionEndpoints.DELETE("/bans", s.requireScope(scopeRequests), s.unbanRequester)
+
 		sessionEndpoints.OPTIONS("/config", s.cors)
 		sessionEndpoints.GET("/config", s.getConfig)
-		sessionEndpoints.POST("/config", s.setConfig)
+		sessionEndpoints.POST("/config", s.requireScope(scopeConfig), s.setConfig)
+
+		sessionEndpoints.OPTIONS("/state", s.cors)
+		sessionEndpoints.GET("/state", s.getState)
+
+		sessionEndpoints.OPTIONS("/time", s.cors)
+		sessionEndpoints.GET("/time", s.getTime)
+
+		sessionEndpoints.GET("/subscribe", s.subscribe)
+
+		sessionEndpoints.OPTIONS("/events", s.cors)
+		sessionEndpoints.GET("/events", s.subscribeEvents)
 	}
 	rr.POST("/auth/twitch", s.twitchAuth)
+
+	// verifiers that append the well-known path to the issuer find the keys
+	// here; WellKnown serves them at the host root
+	rr.OPTIONS("/.well-known/jwks.json", s.cors)
+	rr.GET("/.well-known/jwks.json", s.cors, s.jwks)
+}
+
+// WellKnown serves the keys session tokens are verified with at the host
+// root, where verifiers look for them. Mount it on the engine's root group
+// when Server is mounted under a prefix.
+func WellKnown(rr *gin.RouterGroup) {
+	s := &server{jwt: newJWT()}
+
+	rr.OPTIONS("/.well-known/jwks.json", s.cors)
+	rr.GET("/.well-known/jwks.json", s.cors, s.jwks)
+}
+
+// newJWT configures signing and verifying session tokens from the env
+func newJWT() *j {
+	var j = new(j)
+	j.secret = []byte(os.Getenv("JWT_SECRET"))
+	j.parser = &jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Alg()}}
+	j.issuer = defaultJWTIssuer
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		j.issuer = iss
+	}
+	j.audience = defaultJWTAudience
+	if aud := os.Getenv("JWT_AUDIENCE"); aud != "" {
+		j.audience = aud
+	}
+	j.ttl = time.Hour
+	if ttl, err := strconv.Atoi(os.Getenv("SESSION_TOKEN_TTL")); err == nil && ttl > 0 {
+		j.ttl = time.Duration(ttl) * time.Second
+	}
+	if path := os.Getenv("JWT_PRIVATE_KEY"); path != "" {
+		if err := j.loadKeys(path, os.Getenv("JWT_PUBLIC_KEYS")); err != nil {
+			panic(err)
+		}
+	}
+	return j
 }
 
 func generateSessionCode(_ int) ([]byte, error) {
@@ -185,6 +385,24 @@ func (t *Time) UnmarshalJSON(s []byte) (err error) {
 	return nil
 }
 
+// MilliTime is a JSON un/marshallable type of time.Time with millisecond precision
+type MilliTime time.Time
+
+// MarshalJSON is used to convert the timestamp to JSON
+func (t MilliTime) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatInt(time.Time(t).UnixNano()/int64(time.Millisecond), 10)), nil
+}
+
+// UnmarshalJSON is used to convert the timestamp from JSON
+func (t *MilliTime) UnmarshalJSON(s []byte) (err error) {
+	q, err := strconv.ParseInt(string(s), 10, 64)
+	if err != nil {
+		return err
+	}
+	*(*time.Time)(t) = time.Unix(0, q*int64(time.Millisecond))
+	return nil
+}
+
 func extractAll(c *gin.Context) (nonce string, nonceChecksum string, data string, hash string, err error) {
 	b := new(SessionClaim)
 
@@ -4,13 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -24,40 +24,67 @@ var _ = (func() interface{} {
 	return nil
 })()
 
-var _pubsubsecret = "secret"
+// fakePublisher records published messages in memory
+type fakePublisher struct {
+	mu          sync.Mutex
+	published   map[string][][]byte
+	subscribers map[string]int64
+}
+
+func (p *fakePublisher) Publish(channel string, data []byte) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.published[channel] = append(p.published[channel], data)
+	return p.subscribers[channel], nil
+}
+
+func (p *fakePublisher) Subscribers(channel string) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.subscribers[channel], nil
+}
+
+// Active treats channels that were published to like nchan does
+func (p *fakePublisher) Active(channel string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.subscribers[channel] > 0 || len(p.published[channel]) > 0, nil
+}
+
+func (p *fakePublisher) Close() error {
+	return nil
+}
+
+func (p *fakePublisher) last(channel string) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if msgs := p.published[channel]; len(msgs) > 0 {
+		return msgs[len(msgs)-1]
+	}
+	return nil
+}
+
+var _fakePublisher = &fakePublisher{
+	published: make(map[string][][]byte),
+	subscribers: map[string]int64{
+		"exist": 1,
+	},
+}
 
 func TestMain(m *testing.M) {
 	gin.SetMode(gin.TestMode)
 	log.Print("main")
-	mockPubSubHandler := gin.New()
 
 	os.Setenv("POW_SECRET", "secret")
 	os.Setenv("POW_DIFFICULTY", "1")
 
-	mockPubSubHandler.POST("/pub", func(c *gin.Context) {
-		if c.GetHeader("authorization") != _pubsubsecret {
-			c.Status(401)
-			return
-		}
-
-		body, _ := ioutil.ReadAll(c.Request.Body)
-		log.Printf("PubSub got: %x", body)
-		c.String(200, "ok")
-	})
-
-	mockPubSubHandler.GET("/channels-stats", func(c *gin.Context) {
-		switch id := c.Query("id"); id {
-		case "exist":
-			c.Status(200)
-		case "notexist":
-			c.Status(404)
-		}
-	})
-
-	mockPubSubServer := httptest.NewServer(mockPubSubHandler)
-	defer mockPubSubServer.Close()
+	publishers["fake"] = func(_ *r) (Publisher, error) {
+		return _fakePublisher, nil
+	}
+	os.Setenv("PUBSUB_DRIVER", "fake")
+	// tests issue listener tokens without solving problems
+	os.Setenv("LISTENER_POW", "false")
 
-	os.Setenv("PUBSUB_URL", mockPubSubServer.URL)
 	code := m.Run()
 	os.Exit(code)
 }
@@ -83,16 +110,57 @@ func TestServerV1(t *testing.T) {
 		{"/session/issue", "GET"},
 		{"/session/claim", "OPTIONS"},
 		{"/session/claim", "POST"},
+		{"/session/vanity", "OPTIONS"},
+		{"/session/vanity", "POST"},
+		{"/session/vanity", "DELETE"},
+		{"/session/vanity/claim", "OPTIONS"},
+		{"/session/vanity/claim", "POST"},
 		{"/session/refresh", "OPTIONS"},
 		{"/session/refresh", "POST"},
+		{"/session/end", "OPTIONS"},
+		{"/session/end", "POST"},
+		{"/session/revoke", "OPTIONS"},
+		{"/session/revoke", "POST"},
+		{"/session/delegates", "OPTIONS"},
+		{"/session/delegates", "POST"},
+		{"/session/delegates", "GET"},
+		{"/session/delegates", "DELETE"},
+		{"/session/join", "OPTIONS"},
+		{"/session/join", "POST"},
+		{"/session/listener", "OPTIONS"},
+		{"/session/listener", "POST"},
 		{"/session/update", "OPTIONS"},
 		{"/session/update", "POST"},
 		{"/session/request", "OPTIONS"},
 		{"/session/request", "POST"},
+		{"/session/requests", "OPTIONS"},
+		{"/session/requests", "GET"},
+		{"/session/requests", "DELETE"},
+		{"/session/requests/ranked", "OPTIONS"},
+		{"/session/requests/ranked", "GET"},
+		{"/session/requests/vote", "OPTIONS"},
+		{"/session/requests/vote", "POST"},
+		{"/session/requests/accept", "OPTIONS"},
+		{"/session/requests/accept", "POST"},
+		{"/session/requests/reject", "OPTIONS"},
+		{"/session/requests/reject", "POST"},
+		{"/session/bans", "OPTIONS"},
+		{"/session/bans", "POST"},
+		{"/session/bans", "GET"},
+		{"/session/bans", "DELETE"},
 		{"/session/config", "OPTIONS"},
 		{"/session/config", "GET"},
 		{"/session/config", "POST"},
+		{"/session/state", "OPTIONS"},
+		{"/session/state", "GET"},
+		{"/session/time", "OPTIONS"},
+		{"/session/time", "GET"},
+		{"/session/subscribe", "GET"},
+		{"/session/events", "OPTIONS"},
+		{"/session/events", "GET"},
 		{"/auth/twitch", "POST"},
+		{"/.well-known/jwks.json", "OPTIONS"},
+		{"/.well-known/jwks.json", "GET"},
 	}
 
 	for _, testCase := range cases {
@@ -237,3 +305,27 @@ func Test_generateSessionCode(t *testing.T) {
 		}
 	}
 }
+
+func TestMilliTime(t *testing.T) {
+	now := time.Unix(1600000000, 123456789)
+
+	b, err := json.Marshal(MilliTime(now))
+	if err != nil {
+		t.Fatalf("MarshalJSON errored with: %v", err)
+	}
+	if expect := "1600000000123"; string(b) != expect {
+		t.Errorf("MarshalJSON returned %s, expected %v", b, expect)
+	}
+
+	var got MilliTime
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("UnmarshalJSON errored with: %v", err)
+	}
+	if expect := now.Truncate(time.Millisecond); !time.Time(got).Equal(expect) {
+		t.Errorf("UnmarshalJSON returned %v, expected %v", time.Time(got), expect)
+	}
+
+	if err := json.Unmarshal([]byte("\"abc\""), &got); err == nil {
+		t.Error("UnmarshalJSON didn't error on a string")
+	}
+}
@@ -1,64 +1,133 @@
 package pogifyapi
 
 import (
-	"io/ioutil"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 )
 
+// maxUpdateSize is the largest update body accepted from a host
+const maxUpdateSize = 16 << 10
+
+// playbackUpdate is the state of the host's player
+type playbackUpdate struct {
+	URI          string   `json:"uri" binding:"required,max=256"`
+	Position     int64    `json:"position" binding:"min=0"`
+	Paused       bool     `json:"paused"`
+	PlaybackRate float64  `json:"playbackRate" binding:"omitempty,gt=0,lte=4"`
+	Timestamp    int64    `json:"timestamp" binding:"required,gt=0"`
+	Queue        []string `json:"queue,omitempty" binding:"omitempty,max=100,dive,required,max=256"`
+}
+
+// readUpdate reads and validates the update body. In raw mode the body is
+// passed through as is for older clients.
+func (s *server) readUpdate(c *gin.Context) ([]byte, error) {
+	if c.Request.Body == nil {
+		return nil, errors.New("missing body")
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUpdateSize)
+
+	if s.rawUpdates {
+		return c.GetRawData()
+	}
+
+	var update playbackUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		return nil, err
+	}
+
+	if update.PlaybackRate == 0 {
+		update.PlaybackRate = 1
+	}
+
+	return json.Marshal(update)
+}
+
 func (s *server) postUpdate(c *gin.Context) {
-	sessionToken := c.GetHeader("X-Session-Token")
-	if sessionToken == "" {
-		c.String(400, "missing X-Session-Token header")
+	principal, err := getPrincipal(c)
+	if err != nil {
+		c.AbortWithError(500, err)
 		return
 	}
 
-	token, err := jwt.Parse(sessionToken, func(token *jwt.Token) (interface{}, error) {
-		return s.jwt.secret, nil
-	})
-
+	sessionID := principal.Session
+	data, err := s.readUpdate(c)
 	if err != nil {
-		c.String(401, err.Error())
+		c.Error(err)
+		c.String(400, fmt.Sprint(err))
 		return
 	}
 
-	if token.Valid {
-		sessionID := token.Claims.(jwt.MapClaims)["session"].(string)
-		data, _ := c.GetRawData()
+	receivedAt := time.Now()
 
-		ch := make(chan *http.Response)
-		errCh := make(chan error)
+	prev, err := s.redis.getState(sessionID)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
 
-		go s.pubsub.pub(ch, errCh, sessionID, data)
+	// seq is filled in when the update is stored
+	keyframe, err := json.Marshal(envelope{
+		Time:    MilliTime(receivedAt),
+		Type:    updateKeyframe,
+		Payload: jsonOrString(data),
+	})
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
 
-		var res *http.Response
-		select {
-		case res = <-ch:
-		case err := <-errCh:
-			c.AbortWithError(500, err)
-			return
+	// the patch is only published if nothing was stored after prev; the
+	// first update, concurrent updates and raw payloads get a keyframe
+	var patch []byte
+	var base int64
+	if !s.rawUpdates && prev != nil {
+		if diff, err := diffState(prev.Data, data); err == nil {
+			patch, err = json.Marshal(envelope{
+				Time:    MilliTime(receivedAt),
+				Type:    updatePatch,
+				Payload: json.RawMessage(diff),
+			})
+			if err != nil {
+				c.AbortWithError(500, err)
+				return
+			}
+			base = prev.Seq
 		}
+	}
 
-		if res.StatusCode > 399 {
-			log.Printf("Pubsub error with: %v", res.StatusCode)
-			c.AbortWithStatus(500)
-			return
-		}
+	seq, msg, err := s.redis.publishUpdate(sessionID, keyframe, patch, base, receivedAt)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
 
-		body, err := ioutil.ReadAll(res.Body)
-		defer res.Body.Close()
+	subscribers, err := s.pubsub.Publish(sessionID, msg)
+	if err != nil {
+		log.Printf("Pubsub error with: %v", err)
+		c.AbortWithError(500, err)
+		return
+	}
 
-		if err != nil {
-			c.AbortWithError(500, err)
-			return
-		}
+	c.JSON(200, gin.H{
+		"seq":         seq,
+		"subscribers": subscribers,
+	})
+}
 
-		c.Data(200, "application/json", body)
-	} else {
-		c.AbortWithError(403, err)
+// diffState returns a merge patch from the payload of a stored keyframe to data
+func diffState(prevState []byte, data []byte) ([]byte, error) {
+	var prev struct {
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(prevState, &prev); err != nil {
+		return nil, err
 	}
 
+	return createMergePatch(prev.Payload, data)
 }
@@ -1,6 +1,8 @@
 package pogifyapi
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -14,12 +16,20 @@ import (
 	gonanoid "github.com/matoous/go-nanoid"
 )
 
+// testUpdate returns a valid update for uri in its normalized form
+func testUpdate(uri string) string {
+	return fmt.Sprintf("{\"uri\":%q,\"position\":1000,\"paused\":false,\"playbackRate\":1,\"timestamp\":1600000000000}", uri)
+}
+
 func Test_server_postUpdate(t *testing.T) {
 	sessionCode, _ := gonanoid.ID(10)
 	claims := sessionJwtClaims{
-		sessionCode,
-		jwt.StandardClaims{
+		Session: sessionCode,
+		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Issuer:    defaultJWTIssuer,
+			Audience:  defaultJWTAudience,
 		},
 	}
 
@@ -37,7 +47,6 @@ func Test_server_postUpdate(t *testing.T) {
 		return
 	}
 	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
-	os.Setenv("PUBSUB_SECRET", _pubsubsecret)
 
 	router := gin.Default()
 
@@ -63,26 +72,133 @@ func Test_server_postUpdate(t *testing.T) {
 			t.Errorf("returned code %v, expected %v", w.Code, 401)
 		}
 	})
+	t.Run("revoked token", func(t *testing.T) {
+		revoked := claims
+		revoked.Id = "revoked"
+		revokedToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, revoked).SignedString([]byte(os.Getenv("JWT_SECRET")))
+		mr.Set("revoked:revoked", "1")
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/update", strings.NewReader(testUpdate("spotify:track:a")))
+		req.Header.Add("X-Session-Token", revokedToken)
+		router.ServeHTTP(w, req)
+
+		if w.Code != 401 {
+			t.Errorf("returned code %v, expected %v", w.Code, 401)
+		}
+	})
 	t.Run("proper token, empty body", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("POST", "/session/update", nil)
 		req.Header.Add("x-session-token", mockToken)
 		router.ServeHTTP(w, req)
 
-		if w.Code != 500 {
-			t.Errorf("returned code %v, expected %v", w.Code, 500)
+		if w.Code != 400 {
+			t.Errorf("returned code %v, expected %v", w.Code, 400)
 		}
 	})
 
+	invalidBodies := []struct {
+		name string
+		body string
+	}{
+		{"not json", "not json"},
+		{"missing uri", "{\"position\":1000,\"timestamp\":1600000000000}"},
+		{"negative position", "{\"uri\":\"spotify:track:a\",\"position\":-1,\"timestamp\":1600000000000}"},
+		{"bad playback rate", "{\"uri\":\"spotify:track:a\",\"playbackRate\":-1,\"timestamp\":1600000000000}"},
+		{"empty queue item", "{\"uri\":\"spotify:track:a\",\"timestamp\":1600000000000,\"queue\":[\"\"]}"},
+		{"oversized", "{\"uri\":\"spotify:track:a\",\"timestamp\":1600000000000,\"queue\":[" + strings.Repeat("\""+strings.Repeat("a", 250)+"\",", 70) + "\"a\"]}"},
+	}
+	for _, tt := range invalidBodies {
+		t.Run("proper token, "+tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/session/update", strings.NewReader(tt.body))
+			req.Header.Add("x-session-token", mockToken)
+			router.ServeHTTP(w, req)
+
+			if w.Code != 400 {
+				t.Errorf("returned code %v, expected %v", w.Code, 400)
+			}
+		})
+	}
+
 	t.Run("proper token, body", func(t *testing.T) {
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/session/update", strings.NewReader("{\"nothing\":\"nothing\"}"))
+		req, _ := http.NewRequest("POST", "/session/update", strings.NewReader("{\"uri\":\"spotify:track:a\",\"position\":1000,\"timestamp\":1600000000000,\"extra\":1}"))
 		req.Header.Add("x-session-token", mockToken)
 		router.ServeHTTP(w, req)
 
 		if w.Code != 200 {
 			t.Errorf("returned code %v, expected %v", w.Code, 200)
 		}
+
+		e := unwrap(t, _fakePublisher.last(sessionCode))
+		if got := string(e.Payload); got != testUpdate("spotify:track:a") {
+			t.Errorf("published %v to %v", got, sessionCode)
+		}
+		if e.Seq != 1 {
+			t.Errorf("published seq %v, expected %v", e.Seq, 1)
+		}
+		if e.Time == 0 {
+			t.Error("published envelope without a time")
+		}
+
+		var res map[string]int64
+		json.Unmarshal(w.Body.Bytes(), &res)
+		if res["seq"] != e.Seq {
+			t.Errorf("returned seq %v, published %v", res["seq"], e.Seq)
+		}
 	})
 
+	t.Run("patches between keyframes", func(t *testing.T) {
+		mr.FlushAll()
+
+		for i := int64(1); i <= keyframeInterval; i++ {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/session/update", strings.NewReader(testUpdate(fmt.Sprintf("spotify:track:%v", i))))
+			req.Header.Add("x-session-token", mockToken)
+			router.ServeHTTP(w, req)
+
+			e := unwrap(t, _fakePublisher.last(sessionCode))
+			switch i {
+			case 1, keyframeInterval:
+				if e.Type != updateKeyframe || string(e.Payload) != testUpdate(fmt.Sprintf("spotify:track:%v", i)) {
+					t.Errorf("update %v published %v %s, expected a keyframe", i, e.Type, e.Payload)
+				}
+			default:
+				if expect := fmt.Sprintf("{\"uri\":\"spotify:track:%v\"}", i); e.Type != updatePatch || string(e.Payload) != expect {
+					t.Errorf("update %v published %v %s, expected patch %v", i, e.Type, e.Payload, expect)
+				}
+			}
+		}
+
+		// the stored state is always the full update
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/session/state?session="+sessionCode, nil)
+		router.ServeHTTP(w, req)
+		if expect := testUpdate(fmt.Sprintf("spotify:track:%v", keyframeInterval)); !strings.Contains(w.Body.String(), expect) {
+			t.Errorf("state is %v, expected %v", w.Body.String(), expect)
+		}
+	})
+
+	t.Run("raw updates", func(t *testing.T) {
+		os.Setenv("RAW_UPDATES", "true")
+		defer os.Unsetenv("RAW_UPDATES")
+
+		router := gin.New()
+		Server(router.Group("/"))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/update", strings.NewReader("{\"nothing\":\"nothing\"}"))
+		req.Header.Add("x-session-token", mockToken)
+		router.ServeHTTP(w, req)
+
+		if w.Code != 200 {
+			t.Errorf("returned code %v, expected %v", w.Code, 200)
+		}
+
+		if got := string(unwrap(t, _fakePublisher.last(sessionCode)).Payload); got != "{\"nothing\":\"nothing\"}" {
+			t.Errorf("published %v to %v", got, sessionCode)
+		}
+	})
 }
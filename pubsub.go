@@ -1,33 +1,61 @@
 package pogifyapi
 
 import (
-	"bytes"
-	"net/http"
+	"fmt"
+	"sync"
 )
 
-type pubsub struct {
-	secret string
-	url    string
+// Publisher delivers messages to the listeners of a channel
+type Publisher interface {
+	// Publish sends data to every subscriber of channel and returns how many
+	// subscribers it was delivered to
+	Publish(channel string, data []byte) (int64, error)
+	// Subscribers returns the number of subscribers currently on channel
+	Subscribers(channel string) (int64, error)
+	// Active returns whether the broker knows channel. Brokers that keep
+	// messages, like nchan, know a channel until its messages expire even
+	// without subscribers; the others only while it has subscribers.
+	Active(channel string) (bool, error)
+	// Close releases any resources held by the publisher
+	Close() error
 }
 
-func (p *pubsub) pub(ch chan<- *http.Response, errCh chan<- error, channel string, data []byte) {
-	pub, err := http.NewRequest("POST", p.url+"/pub", bytes.NewReader(data))
-	pub.Header.Add("authorization", p.secret)
-	pubQ := pub.URL.Query()
-	pubQ.Add("id", channel)
-	pub.URL.RawQuery = pubQ.Encode()
+// publishers maps PUBSUB_DRIVER values to Publisher constructors
+var publishers = map[string]func(r *r) (Publisher, error){
+	"nchan": newNchan,
+	"local": newLocalBroker,
+	"redis": newRedisPubSub,
+}
 
-	if err != nil {
-		errCh <- err
-		return
+func newPublisher(driver string, r *r) (Publisher, error) {
+	if driver == "" {
+		driver = "nchan"
 	}
 
-	res, err := http.DefaultClient.Do(pub)
-	if err != nil {
-		errCh <- err
-		return
+	newFn, ok := publishers[driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown PUBSUB_DRIVER: %v", driver)
 	}
 
-	ch <- res
+	return newFn(r)
+}
+
+// Subscriber is implemented by publishers that can deliver messages to
+// listeners connected directly to this server
+type Subscriber interface {
+	Subscribe(channel string) (*Subscription, error)
+}
+
+// Subscription receives the messages published to a channel until closed
+type Subscription struct {
+	// C is closed when the subscription or its publisher is closed
+	C <-chan []byte
+
+	unsubscribe func()
+	once        sync.Once
+}
 
+// Close unsubscribes from the channel
+func (s *Subscription) Close() {
+	s.once.Do(s.unsubscribe)
 }
@@ -3,12 +3,13 @@ package pogifyapi
 import (
 	"context"
 	"crypto/sha1"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/fatih/structs"
 	"github.com/go-redis/redis/v8"
 )
 
@@ -19,7 +20,13 @@ type r struct {
 	refreshTokenTTL string
 }
 
-var newSessionScript = `local c = redis.call("ttl", KEYS[1])
+// vanity codes can only be claimed by their owner, ARGV[3], which is empty
+// for random codes. Returns -1 if the code is someone else's vanity code.
+var newSessionScript = `local owner = redis.call("get", KEYS[2])
+												if (owner ~= false and owner ~= ARGV[3]) then
+													return -1
+													end
+												local c = redis.call("ttl", KEYS[1])
 												if (c < 0) then 
 													redis.call("set", KEYS[1], ARGV[1])
 													redis.call("expire", KEYS[1], ARGV[2])
@@ -27,13 +34,14 @@ var newSessionScript = `local c = redis.call("ttl", KEYS[1])
 													end
 												return 0`
 
-func (r *r) newSession(sessionToken string, refreshToken string) (int64, error) {
+func (r *r) newSession(sessionToken string, refreshToken string, owner string) (int64, error) {
 
 	key := []string{
 		"session:" + sessionToken,
+		"vanity:" + sessionToken,
 	}
 
-	val, err := r.conn.Eval(ctx, newSessionScript, key, refreshToken, r.refreshTokenTTL).Result()
+	val, err := r.conn.Eval(ctx, newSessionScript, key, refreshToken, r.refreshTokenTTL, owner).Result()
 	if err != nil {
 		val = int64(0)
 	}
@@ -48,34 +56,57 @@ var verifyAndSetScript = `
   end
   if (t == ARGV[1]) then
     redis.call("set", KEYS[1], ARGV[2])
+		redis.call("sadd", KEYS[1]..":rotated", redis.sha1hex(ARGV[1]))
 		redis.call("expire", KEYS[1], ARGV[3])
 		redis.call("expire", KEYS[1]..":config", ARGV[3])
+		redis.call("expire", KEYS[1]..":seq", ARGV[3])
+		redis.call("expire", KEYS[1]..":updates", ARGV[3])
+		redis.call("expire", KEYS[1]..":state", ARGV[3])
+		redis.call("expire", KEYS[1]..":rotated", ARGV[3])
+		redis.call("expire", KEYS[1]..":requests", ARGV[3])
+		redis.call("expire", KEYS[1]..":requestQueue", ARGV[3])
+		redis.call("expire", KEYS[1]..":requestVotes", ARGV[3])
+		redis.call("expire", KEYS[1]..":voters", ARGV[3])
+		redis.call("expire", KEYS[1]..":bans", ARGV[3])
     return 1
   end
+  if (redis.call("sismember", KEYS[1]..":rotated", redis.sha1hex(ARGV[1])) == 1) then
+    return -2
+  end
   return 0 
   `
 
+// verifyAndSetNewRefreshToken rotates the session's refresh token. It returns
+// 1 on success, 0 for a wrong token, -1 for an expired session and -2 when an
+// already rotated token is reused.
 func (r *r) verifyAndSetNewRefreshToken(sessionID string, token string, newToken string) (int64, error) {
 	val, err := r.conn.Eval(ctx, verifyAndSetScript, []string{"session:" + sessionID}, token, newToken, r.refreshTokenTTL).Result()
 	return val.(int64), err
 }
 
+// the limit's key is tracked on the session so ending it can delete them
 var requestLimitScript = `
 	local c = redis.call('incr',KEYS[1]) 
-	local r = redis.call('hget', "session:" .. ARGV[1] .. ":config", "RequestInterval")
+	local r = redis.call('hget', KEYS[2], "RequestInterval")
 	if (c <= 1) then 
 		if (r == false) then 
 			redis.call('expire', KEYS[1], 60)
 		else 
 			redis.call('expire', KEYS[1], r) 
 		end
+		redis.call('sadd', KEYS[3], KEYS[1])
+		redis.call('expire', KEYS[3], ARGV[1])
 	end 	
 	return {c, redis.call('ttl', KEYS[1])}`
 
 func (r *r) rateLimitRequest(sessionID string, id string) ([2]int64, error) {
 	bs := hashID(id)
-	key := fmt.Sprintf("requestLimit:%v:%x", sessionID, bs)
-	val, err := r.conn.Eval(ctx, requestLimitScript, []string{key}, sessionID).Result()
+	keys := []string{
+		fmt.Sprintf("requestLimit:%v:%x", sessionID, bs),
+		fmt.Sprintf("session:%v:config", sessionID),
+		fmt.Sprintf("session:%v:requestLimits", sessionID),
+	}
+	val, err := r.conn.Eval(ctx, requestLimitScript, keys, r.refreshTokenTTL).Result()
 
 	valS := new([2]int64)
 	if err == nil {
@@ -92,12 +123,92 @@ func (r *r) reverseRateLimit(sessionID string, id string) (int64, error) {
 	return r.conn.Decr(ctx, fmt.Sprintf("requestLimit:%v:%v", sessionID, bs)).Result()
 }
 
-func (r *r) setSessionConfig(sessionID string, config config) error {
+// the limit's key is tracked on the session so ending it can delete them
+var voteLimitScript = `
+	if (redis.call("sismember", KEYS[1], ARGV[1]) == 0) then
+		if (redis.call("scard", KEYS[1]) >= tonumber(ARGV[2])) then
+			return 0
+		end
+		redis.call("sadd", KEYS[1], ARGV[1])
+		redis.call("expire", KEYS[1], ARGV[3])
+		redis.call("sadd", KEYS[2], KEYS[1])
+		redis.call("expire", KEYS[2], ARGV[3])
+	end
+	return 1`
+
+// rateLimitVote returns false once limit voters other than voter have voted on
+// a request from client
+func (r *r) rateLimitVote(sessionID string, requestID string, client string, voter string, limit int) (bool, error) {
+	keys := []string{
+		fmt.Sprintf("voteLimit:%v:%v:%x", sessionID, requestID, hashID(client)),
+		fmt.Sprintf("session:%v:requestLimits", sessionID),
+	}
+	ok, err := r.conn.Eval(ctx, voteLimitScript, keys, voter, limit, r.refreshTokenTTL).Int()
+	return ok == 1, err
+}
+
+// sessionPassword returns the session's password hash, or "" for public
+// sessions, and how many times the password was set
+func (r *r) sessionPassword(sessionID string) (string, int64, error) {
+	vals, err := r.conn.HMGet(ctx, fmt.Sprintf("session:%v:config", sessionID), "Password", "PasswordVersion").Result()
+	if err != nil {
+		return "", 0, err
+	}
+
+	hash, _ := vals[0].(string)
+	var version int64
+	if v, ok := vals[1].(string); ok {
+		version, _ = strconv.ParseInt(v, 10, 64)
+	}
+	return hash, version, nil
+}
+
+// minuteLimitScript counts calls to KEYS[1] in the current minute
+var minuteLimitScript = `
+	local c = redis.call("incr", KEYS[1])
+	if (c <= 1) then
+		redis.call("expire", KEYS[1], 60)
+	end
+	return c`
+
+// rateLimitJoin counts join attempts by client on a session in the current
+// minute
+func (r *r) rateLimitJoin(sessionID string, client string) (int64, error) {
+	key := fmt.Sprintf("joinLimit:%v:%x", sessionID, hashID(client))
+	return r.conn.Eval(ctx, minuteLimitScript, []string{key}).Int64()
+}
+
+// rateLimitSessionJoin counts passwords tried on a session in the current
+// minute from every client
+func (r *r) rateLimitSessionJoin(sessionID string) (int64, error) {
+	return r.conn.Eval(ctx, minuteLimitScript, []string{"joinLimit:" + sessionID}).Int64()
+}
+
+// rateLimitListener counts anonymous listener tokens issued to client on a
+// session in the current minute
+func (r *r) rateLimitListener(sessionID string, client string) (int64, error) {
+	key := fmt.Sprintf("listenerLimit:%v:%x", sessionID, hashID(client))
+	return r.conn.Eval(ctx, minuteLimitScript, []string{key}).Int64()
+}
+
+// spendProblem marks a solved PoW problem as used and returns false if it
+// already was. Problems expire after a minute so two is enough.
+func (r *r) spendProblem(checksum string) (bool, error) {
+	return r.conn.SetNX(ctx, "powSpent:"+checksum, 1, 2*time.Minute).Result()
+}
+
+// setSessionConfig sets the given config fields, keyed by their name in
+// config, and leaves the others as they are. Setting the password bumps its
+// version so listener tokens issued for the old one stop working.
+func (r *r) setSessionConfig(sessionID string, fields map[string]interface{}) error {
 	parsedStr, _ := strconv.ParseInt(r.refreshTokenTTL, 10, 64)
 
 	key := fmt.Sprintf("session:%v:config", sessionID)
 	pipe := r.conn.TxPipeline()
-	pipe.HSet(ctx, key, structs.Map(config)).Result()
+	pipe.HSet(ctx, key, fields)
+	if _, ok := fields["Password"]; ok {
+		pipe.HIncrBy(ctx, key, "PasswordVersion", 1)
+	}
 	pipe.Expire(ctx, key, time.Duration(parsedStr)*time.Second)
 	_, err := pipe.Exec(ctx)
 	return err
@@ -122,6 +233,597 @@ func (r *r) getSessionConfig(sessionID string) (*config, error) {
 
 }
 
+// updateHistoryLength is how many updates are kept for resuming listeners
+const updateHistoryLength = 64
+
+type update struct {
+	Seq  int64
+	Data []byte
+}
+
+type sessionState struct {
+	Seq  int64
+	Data []byte
+	Time time.Time
+}
+
+func (r *r) nextUpdateSeq(sessionID string) (int64, error) {
+	parsedStr, _ := strconv.ParseInt(r.refreshTokenTTL, 10, 64)
+
+	key := fmt.Sprintf("session:%v:seq", sessionID)
+	pipe := r.conn.TxPipeline()
+	seq := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, time.Duration(parsedStr)*time.Second)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return seq.Val(), nil
+}
+
+// updateSeq returns the seq of the session's last update, or 0 if there's
+// been none
+func (r *r) updateSeq(sessionID string) (int64, error) {
+	seq, err := r.conn.Get(ctx, fmt.Sprintf("session:%v:seq", sessionID)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// published updates are stored as "<seq>:<data>" members scored by seq.
+// The full state as of the latest one is kept for listeners that join late.
+// Numbering and storing happen together so concurrent updates can't patch
+// against the wrong state or move the state back. ARGV[1] is the keyframe and
+// ARGV[2] a patch from the state at seq ARGV[3], both marshalled without a
+// seq; the patch is only used if it directly follows that state and no
+// keyframe is due. Returns the seq and the stored message.
+var publishUpdateScript = `
+	local seq = redis.call("incr", KEYS[3])
+	local stored = tonumber(redis.call("hget", KEYS[2], "seq") or "0")
+	local function withSeq(msg)
+		return '{"seq":' .. seq .. ',' .. string.sub(msg, 2)
+	end
+	local state = withSeq(ARGV[1])
+	local msg = state
+	if (ARGV[2] ~= "" and stored == tonumber(ARGV[3]) and seq == stored + 1 and seq % tonumber(ARGV[4]) ~= 0) then
+		msg = withSeq(ARGV[2])
+	end
+	redis.call("zadd", KEYS[1], seq, seq .. ":" .. msg)
+	redis.call("zremrangebyrank", KEYS[1], 0, -(tonumber(ARGV[5]) + 1))
+	if (seq > stored) then
+		redis.call("hset", KEYS[2], "seq", seq, "data", state, "time", ARGV[7])
+	end
+	redis.call("expire", KEYS[1], ARGV[6])
+	redis.call("expire", KEYS[2], ARGV[6])
+	redis.call("expire", KEYS[3], ARGV[6])
+	return {seq, msg}`
+
+// publishUpdate numbers and stores an update. keyframe and patch are
+// envelopes without a seq and patch is against the state at base; pass a nil
+// patch to always store the keyframe. It returns the seq and the envelope to
+// publish.
+func (r *r) publishUpdate(sessionID string, keyframe []byte, patch []byte, base int64, t time.Time) (int64, []byte, error) {
+	keys := []string{
+		fmt.Sprintf("session:%v:updates", sessionID),
+		fmt.Sprintf("session:%v:state", sessionID),
+		fmt.Sprintf("session:%v:seq", sessionID),
+	}
+	vals, err := r.conn.Eval(ctx, publishUpdateScript, keys, keyframe, patch, base, keyframeInterval, updateHistoryLength, r.refreshTokenTTL, t.UnixNano()).Result()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	res := vals.([]interface{})
+	return res[0].(int64), []byte(res[1].(string)), nil
+}
+
+func (r *r) updatesSince(sessionID string, seq int64) ([]update, error) {
+	key := fmt.Sprintf("session:%v:updates", sessionID)
+	members, err := r.conn.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: fmt.Sprintf("(%v", seq),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make([]update, 0, len(members))
+	for _, m := range members {
+		split := strings.SplitN(m, ":", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("malformed update in %v", key)
+		}
+		s, err := strconv.ParseInt(split[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, update{s, []byte(split[1])})
+	}
+
+	return updates, nil
+}
+
+// getState returns the last update stored for a session or nil if there
+// hasn't been one
+func (r *r) getState(sessionID string) (*sessionState, error) {
+	state, err := r.conn.HGetAll(ctx, fmt.Sprintf("session:%v:state", sessionID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(state) == 0 {
+		return nil, nil
+	}
+
+	seq, err := strconv.ParseInt(state["seq"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	t, err := strconv.ParseInt(state["time"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionState{seq, []byte(state["data"]), time.Unix(0, t)}, nil
+}
+
+// sessionKeys returns the keys stored for a session. The song keys of the
+// duplicate window aren't included; they expire with the window.
+func sessionKeys(sessionID string) []string {
+	keys := []string{"session:" + sessionID}
+	for _, suffix := range []string{"config", "state", "updates", "seq", "rotated", "requests", "requestQueue", "requestVotes", "voters", "bans", "delegates", "requestLimits"} {
+		keys = append(keys, fmt.Sprintf("session:%v:%v", sessionID, suffix))
+	}
+	return keys
+}
+
+var endSessionScript = `
+	redis.call("del", unpack(KEYS, 2))
+	redis.call("set", KEYS[1], ARGV[1], "ex", ARGV[2])
+	return 1`
+
+// endSession deletes everything stored for a session and remembers when it
+// ended for tokenTTL so tokens issued before then are rejected until they
+// expire
+func (r *r) endSession(sessionID string, tokenTTL time.Duration) error {
+	limits, err := r.conn.SMembers(ctx, fmt.Sprintf("session:%v:requestLimits", sessionID)).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := append([]string{"ended:" + sessionID}, sessionKeys(sessionID)...)
+	keys = append(keys, limits...)
+	return r.conn.Eval(ctx, endSessionScript, keys, time.Now().Unix(), int64(tokenTTL.Seconds())).Err()
+}
+
+// sessionEnded returns whether a token issued at issuedAt belongs to a session
+// that has since ended
+func (r *r) sessionEnded(sessionID string, issuedAt int64) (bool, error) {
+	endedAt, err := r.conn.Get(ctx, "ended:"+sessionID).Int64()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return issuedAt <= endedAt, nil
+}
+
+// revokeToken rejects the session token with ID jti until it expires at
+// expiresAt
+func (r *r) revokeToken(jti string, expiresAt int64) error {
+	ttl := time.Until(time.Unix(expiresAt, 0))
+	// expired tokens are rejected anyway
+	if ttl <= 0 {
+		return nil
+	}
+
+	return r.conn.Set(ctx, "revoked:"+jti, 1, ttl).Err()
+}
+
+// tokenRevoked returns whether the session token with ID jti was revoked
+func (r *r) tokenRevoked(jti string) (bool, error) {
+	n, err := r.conn.Exists(ctx, "revoked:"+jti).Result()
+	return n == 1, err
+}
+
+// a code can't be reserved while someone else has a session on it
+var reserveVanityScript = `
+	local owner = redis.call("get", KEYS[1])
+	if (owner ~= false and owner ~= ARGV[1]) then
+		return 0
+	end
+	if (owner == false and redis.call("exists", KEYS[3]) == 1) then
+		return 0
+	end
+	local old = redis.call("get", KEYS[2])
+	if (old ~= false and old ~= ARGV[2]) then
+		redis.call("del", "vanity:" .. old)
+	end
+	redis.call("set", KEYS[1], ARGV[1], "ex", ARGV[3])
+	redis.call("set", KEYS[2], ARGV[2], "ex", ARGV[3])
+	return 1`
+
+// reserveVanity reserves code for owner for ttl, releasing owner's previous
+// code. It returns 0 if someone else owns code or has a session on it.
+func (r *r) reserveVanity(code string, owner string, ttl time.Duration) (int64, error) {
+	keys := []string{"vanity:" + code, "vanityOwner:" + owner, "session:" + code}
+	return r.conn.Eval(ctx, reserveVanityScript, keys, owner, code, int64(ttl.Seconds())).Int64()
+}
+
+// touchVanity keeps owner's code reserved for another ttl
+func (r *r) touchVanity(code string, owner string, ttl time.Duration) error {
+	pipe := r.conn.TxPipeline()
+	pipe.Expire(ctx, "vanity:"+code, ttl)
+	pipe.Expire(ctx, "vanityOwner:"+owner, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+var releaseVanityScript = `
+	local code = redis.call("get", KEYS[1])
+	if (code == false) then
+		return false
+	end
+	redis.call("del", "vanity:" .. code, KEYS[1])
+	return code`
+
+// releaseVanity releases owner's code and returns it, or "" if owner had none
+func (r *r) releaseVanity(owner string) (string, error) {
+	code, err := r.conn.Eval(ctx, releaseVanityScript, []string{"vanityOwner:" + owner}).Text()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return code, err
+}
+
+// vanityCode returns owner's code, or "" if owner has none
+func (r *r) vanityCode(owner string) (string, error) {
+	code, err := r.conn.Get(ctx, "vanityOwner:"+owner).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return code, err
+}
+
+// sessionExists returns whether a session has been claimed and not ended
+func (r *r) sessionExists(sessionID string) (bool, error) {
+	n, err := r.conn.Exists(ctx, "session:"+sessionID).Result()
+	return n == 1, err
+}
+
+// sessionTTL returns how long a session has left before it expires, 0 if it
+// doesn't expire or a negative duration if it doesn't exist
+func (r *r) sessionTTL(sessionID string) (time.Duration, error) {
+	ttl, err := r.conn.TTL(ctx, "session:"+sessionID).Result()
+	if ttl == -1 {
+		return 0, err
+	}
+	return ttl, err
+}
+
+// requests are stored as JSON by ID, ordered by a sorted set scored by time.
+// Within the session's duplicate window a song's key points at the request
+// that first asked for it: duplicates either count towards it while it's
+// pending or are rejected. Its count is the number of distinct requesters,
+// kept in a set next to the song's key.
+// Returns {0} for a new request, {1, merged} or {2, original} for a duplicate.
+var queueRequestScript = `
+	local window = tonumber(redis.call("hget", KEYS[5], "DuplicateWindow") or "0")
+	if (window > 0) then
+		local original = redis.call("get", KEYS[6])
+		local v = original and redis.call("hget", KEYS[1], original)
+		if (v) then
+			if (redis.call("hget", KEYS[5], "DuplicatePolicy") == "reject") then
+				return {2, v}
+			end
+			local q = cjson.decode(v)
+			if (q["status"] == "pending") then
+				redis.call("sadd", KEYS[7], ARGV[5])
+				q["count"] = redis.call("scard", KEYS[7])
+				v = cjson.encode(q)
+				redis.call("hset", KEYS[1], original, v)
+				return {1, v}
+			end
+		end
+	end
+	redis.call("hset", KEYS[1], ARGV[1], ARGV[2])
+	redis.call("zadd", KEYS[2], ARGV[3], ARGV[1])
+	redis.call("expire", KEYS[1], ARGV[4])
+	redis.call("expire", KEYS[2], ARGV[4])
+	if (window > 0) then
+		redis.call("set", KEYS[6], ARGV[1], "ex", window)
+		redis.call("del", KEYS[7])
+		redis.call("sadd", KEYS[7], ARGV[5])
+		redis.call("expire", KEYS[7], window)
+	end
+	return {0}`
+
+// requestKeys returns the keys of the session's requests, their order, their
+// vote totals and each voter's vote by "<request>:<voter>"
+func requestKeys(sessionID string) []string {
+	return []string{
+		fmt.Sprintf("session:%v:requests", sessionID),
+		fmt.Sprintf("session:%v:requestQueue", sessionID),
+		fmt.Sprintf("session:%v:requestVotes", sessionID),
+		fmt.Sprintf("session:%v:voters", sessionID),
+	}
+}
+
+// queueRequest stores q in the session's queue until the session expires. If
+// q duplicates a recent request it returns duplicateMerge with the merged
+// request or duplicateReject with the original instead.
+func (r *r) queueRequest(sessionID string, q queuedRequest) (string, *queuedRequest, error) {
+	b, err := json.Marshal(q)
+	if err != nil {
+		return "", nil, err
+	}
+
+	song := fmt.Sprintf("session:%v:song:%v:%v:%v", sessionID, q.Song.Provider, q.Song.Kind, q.Song.ID)
+	keys := append(requestKeys(sessionID),
+		fmt.Sprintf("session:%v:config", sessionID),
+		song,
+		song+":requesters",
+	)
+	vals, err := r.conn.Eval(ctx, queueRequestScript, keys, q.ID, b, q.Time, r.refreshTokenTTL, q.Requester).Result()
+	if err != nil {
+		return "", nil, err
+	}
+
+	res := vals.([]interface{})
+	if res[0].(int64) == 0 {
+		return "", nil, nil
+	}
+
+	var original queuedRequest
+	if err := json.Unmarshal([]byte(res[1].(string)), &original); err != nil {
+		return "", nil, err
+	}
+	if res[0].(int64) == 1 {
+		return duplicateMerge, &original, nil
+	}
+	return duplicateReject, &original, nil
+}
+
+// returns each request followed by its vote total
+var queuedRequestsScript = `
+	local res = {}
+	for _, id in ipairs(redis.call("zrange", KEYS[2], 0, -1)) do
+		table.insert(res, redis.call("hget", KEYS[1], id))
+		table.insert(res, redis.call("zscore", KEYS[3], id))
+	end
+	return res`
+
+// queuedRequests returns the session's requests, oldest first
+func (r *r) queuedRequests(sessionID string) ([]queuedRequest, error) {
+	vals, err := r.conn.Eval(ctx, queuedRequestsScript, requestKeys(sessionID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	vs := vals.([]interface{})
+	requests := make([]queuedRequest, 0, len(vs)/2)
+	for i := 0; i+1 < len(vs); i += 2 {
+		// the queue can outlive a request for a moment on expiry
+		str, ok := vs[i].(string)
+		if !ok {
+			continue
+		}
+		var q queuedRequest
+		if err := json.Unmarshal([]byte(str), &q); err != nil {
+			return nil, err
+		}
+		if votes, ok := vs[i+1].(string); ok {
+			q.Votes, _ = strconv.ParseInt(votes, 10, 64)
+		}
+		requests = append(requests, q)
+	}
+
+	return requests, nil
+}
+
+var voteRequestScript = `
+	local v = redis.call("hget", KEYS[1], ARGV[1])
+	if (v == false) then
+		return {0, 0}
+	end
+	if (cjson.decode(v)["status"] ~= "pending") then
+		return {-1, 0}
+	end
+	local field = ARGV[1] .. ":" .. ARGV[2]
+	local prev = tonumber(redis.call("hget", KEYS[4], field) or "0")
+	local vote = tonumber(ARGV[3])
+	if (vote == 0) then
+		redis.call("hdel", KEYS[4], field)
+	else
+		redis.call("hset", KEYS[4], field, vote)
+	end
+	local votes = redis.call("zincrby", KEYS[3], vote - prev, ARGV[1])
+	redis.call("expire", KEYS[3], ARGV[4])
+	redis.call("expire", KEYS[4], ARGV[4])
+	return {1, tonumber(votes)}`
+
+// voteRequest sets voter's vote on a pending request to 1, -1 or 0 to take
+// it back and returns the request's total. The result is 1 on success, 0 if
+// there's no such request and -1 if it isn't pending anymore.
+func (r *r) voteRequest(sessionID string, requestID string, voter string, vote int) (int64, int64, error) {
+	vals, err := r.conn.Eval(ctx, voteRequestScript, requestKeys(sessionID), requestID, voter, vote, r.refreshTokenTTL).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	res := vals.([]interface{})
+	return res[0].(int64), res[1].(int64), nil
+}
+
+var setRequestStatusScript = `
+	local v = redis.call("hget", KEYS[1], ARGV[1])
+	if (v == false) then
+		return 0
+	end
+	local q = cjson.decode(v)
+	q["status"] = ARGV[2]
+	redis.call("hset", KEYS[1], ARGV[1], cjson.encode(q))
+	return 1`
+
+// setRequestStatus sets the status of a queued request and returns false if
+// there's no such request
+func (r *r) setRequestStatus(sessionID string, requestID string, status string) (bool, error) {
+	n, err := r.conn.Eval(ctx, setRequestStatusScript, requestKeys(sessionID), requestID, status).Int64()
+	return n == 1, err
+}
+
+// clearRequests deletes the session's queue
+func (r *r) clearRequests(sessionID string) error {
+	return r.conn.Del(ctx, requestKeys(sessionID)...).Err()
+}
+
+// banRequester bans requester from a session until expiresAt, or until the
+// session ends if it's 0
+func (r *r) banRequester(sessionID string, requester string, expiresAt int64) error {
+	parsedStr, _ := strconv.ParseInt(r.refreshTokenTTL, 10, 64)
+
+	key := fmt.Sprintf("session:%v:bans", sessionID)
+	pipe := r.conn.TxPipeline()
+	pipe.HSet(ctx, key, requester, expiresAt)
+	pipe.Expire(ctx, key, time.Duration(parsedStr)*time.Second)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// unbanRequester lifts requester's ban and returns whether there was one
+func (r *r) unbanRequester(sessionID string, requester string) (bool, error) {
+	n, err := r.conn.HDel(ctx, fmt.Sprintf("session:%v:bans", sessionID), requester).Result()
+	return n == 1, err
+}
+
+// expired bans are deleted as they're read
+var requesterBannedScript = `
+	local v = redis.call("hget", KEYS[1], ARGV[1])
+	if (v == false) then
+		return 0
+	end
+	local expiresAt = tonumber(v)
+	if (expiresAt ~= 0 and expiresAt <= tonumber(ARGV[2])) then
+		redis.call("hdel", KEYS[1], ARGV[1])
+		return 0
+	end
+	return 1`
+
+// requesterBanned returns whether requester is banned from a session
+func (r *r) requesterBanned(sessionID string, requester string) (bool, error) {
+	key := fmt.Sprintf("session:%v:bans", sessionID)
+	banned, err := r.conn.Eval(ctx, requesterBannedScript, []string{key}, requester, time.Now().Unix()).Int()
+	return banned == 1, err
+}
+
+// returns each unexpired ban's requester followed by when it expires and
+// deletes the rest
+var bansScript = `
+	local bans = {}
+	local all = redis.call("hgetall", KEYS[1])
+	for i = 1, #all, 2 do
+		local expiresAt = tonumber(all[i+1])
+		if (expiresAt ~= 0 and expiresAt <= tonumber(ARGV[1])) then
+			redis.call("hdel", KEYS[1], all[i])
+		else
+			table.insert(bans, all[i])
+			table.insert(bans, all[i+1])
+		end
+	end
+	return bans`
+
+// bans returns the session's banned requesters with when their bans expire
+func (r *r) bans(sessionID string) (map[string]int64, error) {
+	key := fmt.Sprintf("session:%v:bans", sessionID)
+	val, err := r.conn.Eval(ctx, bansScript, []string{key}, time.Now().Unix()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	vals := val.([]interface{})
+	bans := make(map[string]int64, len(vals)/2)
+	for i := 0; i+1 < len(vals); i += 2 {
+		expiresAt, err := strconv.ParseInt(vals[i+1].(string), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		bans[vals[i].(string)] = expiresAt
+	}
+
+	return bans, nil
+}
+
+var addDelegateScript = `
+	redis.call("hset", KEYS[1], ARGV[1], ARGV[2])
+	if (redis.call("ttl", KEYS[1]) < tonumber(ARGV[3])) then
+		redis.call("expire", KEYS[1], ARGV[3])
+	end
+	return 1`
+
+// addDelegate tracks d on a session. The delegates are kept at least as long
+// as ttl so the token isn't revoked early.
+func (r *r) addDelegate(sessionID string, d delegate, ttl time.Duration) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("session:%v:delegates", sessionID)
+	return r.conn.Eval(ctx, addDelegateScript, []string{key}, d.ID, b, int64(ttl.Seconds())).Err()
+}
+
+// delegateExists returns whether the delegate token with ID jti is still
+// tracked on a session
+func (r *r) delegateExists(sessionID string, jti string) (bool, error) {
+	return r.conn.HExists(ctx, fmt.Sprintf("session:%v:delegates", sessionID), jti).Result()
+}
+
+// delegate returns the delegate with ID jti tracked on a session, or nil if
+// there's none
+func (r *r) delegate(sessionID string, jti string) (*delegate, error) {
+	v, err := r.conn.HGet(ctx, fmt.Sprintf("session:%v:delegates", sessionID), jti).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d := new(delegate)
+	return d, json.Unmarshal([]byte(v), d)
+}
+
+// delegates returns the unexpired delegates of a session
+func (r *r) delegates(sessionID string) ([]delegate, error) {
+	vals, err := r.conn.HVals(ctx, fmt.Sprintf("session:%v:delegates", sessionID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	delegates := make([]delegate, 0, len(vals))
+	for _, v := range vals {
+		var d delegate
+		if err := json.Unmarshal([]byte(v), &d); err != nil {
+			return nil, err
+		}
+		if d.ExpiresAt > now {
+			delegates = append(delegates, d)
+		}
+	}
+
+	return delegates, nil
+}
+
+// removeDelegate stops tracking the delegate token with ID jti and returns
+// whether it was tracked
+func (r *r) removeDelegate(sessionID string, jti string) (bool, error) {
+	n, err := r.conn.HDel(ctx, fmt.Sprintf("session:%v:delegates", sessionID), jti).Result()
+	return n == 1, err
+}
+
 func cast(conf *map[string]string) *config {
 	var c config
 	s := reflect.ValueOf(&c).Elem()
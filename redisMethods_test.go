@@ -2,12 +2,14 @@ package pogifyapi
 
 import (
 	"encoding/hex"
+	"fmt"
 	"reflect"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/fatih/structs"
 	"github.com/go-redis/redis/v8"
 )
 
@@ -28,7 +30,7 @@ func Test_r_newSession(t *testing.T) {
 		key := "test1"
 		testValue := "test1"
 
-		ret, err := r.newSession(key, testValue)
+		ret, err := r.newSession(key, testValue, "")
 		if err != nil {
 			t.Fatalf("newSession errored with: %v", err)
 			return
@@ -59,7 +61,7 @@ func Test_r_newSession(t *testing.T) {
 		key := "test1"
 		testValue := "test2"
 		m.FastForward(10 * time.Second)
-		ret, err := r.newSession(key, testValue)
+		ret, err := r.newSession(key, testValue, "")
 		if err != nil {
 			t.Fatalf("newSession errored with: %v", err)
 		}
@@ -114,8 +116,9 @@ func Test_r_verifyAndSetNewRefreshToken(t *testing.T) {
 	}
 
 	// db setup
-	r.newSession(session, token1)
-	r.setSessionConfig(session, config{100})
+	r.newSession(session, token1, "")
+	r.setSessionConfig(session, structs.Map(config{RequestInterval: 100}))
+	r.queueRequest(session, queuedRequest{ID: "request", Time: 1, Status: requestPending})
 	m.FastForward(5 * time.Second)
 
 	res, err := r.verifyAndSetNewRefreshToken(session, token1, token2)
@@ -129,7 +132,7 @@ func Test_r_verifyAndSetNewRefreshToken(t *testing.T) {
 		t.Error("verifyAndSetNewRefreshToken didn't return `1` on correct refresh token")
 	}
 
-	res, err = r.verifyAndSetNewRefreshToken(session, token1, token2)
+	res, err = r.verifyAndSetNewRefreshToken(session, "token0", token2)
 	if err != nil {
 		t.Fatalf("verifyAndSetNewRefreshToken errored with: %s", err)
 		return
@@ -139,6 +142,16 @@ func Test_r_verifyAndSetNewRefreshToken(t *testing.T) {
 		t.Error("verifyAndSetNewRefreshToken didn't return `0` on incorrect refresh token")
 	}
 
+	res, err = r.verifyAndSetNewRefreshToken(session, token1, token2)
+	if err != nil {
+		t.Fatalf("verifyAndSetNewRefreshToken errored with: %s", err)
+		return
+	}
+
+	if res != -2 {
+		t.Error("verifyAndSetNewRefreshToken didn't return `-2` on reused refresh token")
+	}
+
 	newToken, err := m.Get("session:" + session)
 	if err != nil {
 		t.Fatalf("miniRedis errored: %v", err)
@@ -159,6 +172,12 @@ func Test_r_verifyAndSetNewRefreshToken(t *testing.T) {
 	if configTTL != time.Duration(ttl)*time.Second {
 		t.Errorf("verifyAndSetNewRefreshToken didn't reset ttl for config")
 	}
+	if rotatedTTL := m.TTL("session:" + session + ":rotated"); rotatedTTL != time.Duration(ttl)*time.Second {
+		t.Errorf("verifyAndSetNewRefreshToken didn't set ttl for rotated tokens")
+	}
+	if requestsTTL := m.TTL("session:" + session + ":requestQueue"); requestsTTL != time.Duration(ttl)*time.Second {
+		t.Errorf("verifyAndSetNewRefreshToken didn't reset ttl for the request queue")
+	}
 }
 
 func Test_r_rateLimitRequest(t *testing.T) {
@@ -186,7 +205,7 @@ func Test_r_rateLimitRequest(t *testing.T) {
 	}
 
 	m.FlushAll()
-	r.setSessionConfig(session, config{10})
+	r.setSessionConfig(session, structs.Map(config{RequestInterval: 10}))
 
 	valS, err = r.rateLimitRequest(session, id)
 
@@ -203,6 +222,10 @@ func Test_r_rateLimitRequest(t *testing.T) {
 		t.Errorf("rateLimitRequest returned incorrect array. Got: %v Expected %v", valS, expectArr)
 	}
 
+	if ok, _ := m.SIsMember("session:"+session+":requestLimits", fmt.Sprintf("requestLimit:%v:%x", session, hashID(id))); !ok {
+		t.Errorf("rateLimitRequest didn't track its key on the session")
+	}
+
 }
 
 func Test_r_reverseRateLimit(t *testing.T) {
@@ -278,8 +301,8 @@ func Test_r_getSessionConfig(t *testing.T) {
 		t.Errorf("getSessionConfig didn't return `nil` on no conf; instead returned: %#v", nilConf)
 	}
 
-	setConf := config{100}
-	r.setSessionConfig(session, setConf)
+	setConf := config{RequestInterval: 100}
+	r.setSessionConfig(session, structs.Map(setConf))
 
 	gotConf, err := r.getSessionConfig(session)
 
@@ -288,3 +311,169 @@ func Test_r_getSessionConfig(t *testing.T) {
 	}
 
 }
+
+func Test_r_publishUpdate(t *testing.T) {
+	m, err := miniredis.Run()
+	defer m.Close()
+	if err != nil {
+		t.Fatalf("miniRedis errored: %v", err)
+		return
+	}
+
+	var r = new(r)
+	r.conn = redis.NewClient(&redis.Options{
+		Addr: m.Addr(),
+	})
+	r.refreshTokenTTL = "10"
+
+	session := "test"
+
+	state, err := r.getState(session)
+	if err != nil || state != nil {
+		t.Errorf("getState on new session returned %+v, %v", state, err)
+	}
+
+	for i := 1; i <= updateHistoryLength+2; i++ {
+		keyframe := []byte(fmt.Sprintf("{\"full\":%v}", i))
+		seq, msg, err := r.publishUpdate(session, keyframe, nil, 0, time.Now())
+		if err != nil {
+			t.Fatalf("publishUpdate errored with: %v", err)
+		}
+		if seq != int64(i) {
+			t.Fatalf("publishUpdate returned seq %v, expected %v", seq, i)
+		}
+		if expect := fmt.Sprintf("{\"seq\":%v,\"full\":%v}", i, i); string(msg) != expect {
+			t.Fatalf("publishUpdate returned %s, expected %v", msg, expect)
+		}
+	}
+
+	for _, key := range []string{"seq", "updates", "state"} {
+		if ttl := m.TTL("session:" + session + ":" + key); ttl != 10*time.Second {
+			t.Errorf("publishUpdate didn't set ttl on %v", key)
+		}
+	}
+
+	last := int64(updateHistoryLength + 2)
+	state, err = r.getState(session)
+	if err != nil {
+		t.Fatalf("getState errored with: %v", err)
+	}
+	if state.Seq != last || string(state.Data) != fmt.Sprintf("{\"seq\":%v,\"full\":%v}", last, last) {
+		t.Errorf("getState returned %+v, expected the last update", state)
+	}
+	if time.Since(state.Time) > time.Second {
+		t.Errorf("getState returned unexpected time %v", state.Time)
+	}
+
+	all, err := r.updatesSince(session, 0)
+	if err != nil {
+		t.Fatalf("updatesSince errored with: %v", err)
+	}
+	if len(all) != updateHistoryLength {
+		t.Errorf("history wasn't trimmed to %v, has %v", updateHistoryLength, len(all))
+	}
+
+	since, _ := r.updatesSince(session, last-1)
+	if len(since) != 1 || since[0].Seq != last || string(since[0].Data) != string(state.Data) {
+		t.Errorf("updatesSince returned %+v", since)
+	}
+
+	t.Run("patches", func(t *testing.T) {
+		m.FlushAll()
+		r.publishUpdate(session, []byte(`{"full":1}`), nil, 0, time.Now())
+
+		seq, msg, _ := r.publishUpdate(session, []byte(`{"full":2}`), []byte(`{"patch":2}`), 1, time.Now())
+		if string(msg) != fmt.Sprintf(`{"seq":%v,"patch":2}`, seq) {
+			t.Errorf("publishUpdate on top of the state returned %s, expected the patch", msg)
+		}
+
+		// another update was stored after the patch's base
+		seq, msg, _ = r.publishUpdate(session, []byte(`{"full":3}`), []byte(`{"patch":3}`), 1, time.Now())
+		if string(msg) != fmt.Sprintf(`{"seq":%v,"full":3}`, seq) {
+			t.Errorf("publishUpdate on a stale base returned %s, expected the keyframe", msg)
+		}
+
+		// a seq used by something else, like an end event, is a gap
+		m.Incr("session:"+session+":seq", 1)
+		_, msg, _ = r.publishUpdate(session, []byte(`{"full":5}`), []byte(`{"patch":5}`), seq, time.Now())
+		if string(msg) != `{"seq":5,"full":5}` {
+			t.Errorf("publishUpdate after a gap returned %s, expected the keyframe", msg)
+		}
+
+		// the state is always the keyframe
+		if state, _ := r.getState(session); state.Seq != 5 || string(state.Data) != `{"seq":5,"full":5}` {
+			t.Errorf("getState returned %+v", state)
+		}
+	})
+}
+
+func Test_r_sessionEnded(t *testing.T) {
+	m, err := miniredis.Run()
+	defer m.Close()
+	if err != nil {
+		t.Fatalf("miniRedis errored: %v", err)
+		return
+	}
+
+	var r = new(r)
+	r.conn = redis.NewClient(&redis.Options{
+		Addr: m.Addr(),
+	})
+	r.refreshTokenTTL = "10"
+
+	if ended, err := r.sessionEnded("test", 0); err != nil || ended {
+		t.Errorf("sessionEnded on active session returned %v, %v", ended, err)
+	}
+
+	m.Set("ended:test", "100")
+
+	tests := []struct {
+		issuedAt int64
+		want     bool
+	}{
+		{0, true},
+		{100, true},
+		{101, false},
+	}
+	for _, tt := range tests {
+		if got, _ := r.sessionEnded("test", tt.issuedAt); got != tt.want {
+			t.Errorf("sessionEnded(%v) = %v, want %v", tt.issuedAt, got, tt.want)
+		}
+	}
+}
+
+func Test_r_revokeToken(t *testing.T) {
+	m, err := miniredis.Run()
+	defer m.Close()
+	if err != nil {
+		t.Fatalf("miniRedis errored: %v", err)
+		return
+	}
+
+	var r = new(r)
+	r.conn = redis.NewClient(&redis.Options{
+		Addr: m.Addr(),
+	})
+	r.refreshTokenTTL = "10"
+
+	if revoked, err := r.tokenRevoked("a"); err != nil || revoked {
+		t.Errorf("tokenRevoked on unrevoked token returned %v, %v", revoked, err)
+	}
+
+	if err := r.revokeToken("a", time.Now().Add(time.Minute).Unix()); err != nil {
+		t.Fatalf("revokeToken errored with: %v", err)
+	}
+	if revoked, err := r.tokenRevoked("a"); err != nil || !revoked {
+		t.Errorf("tokenRevoked on revoked token returned %v, %v", revoked, err)
+	}
+	if ttl := m.TTL("revoked:a"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("revokeToken set ttl %v, expected until the token expires", ttl)
+	}
+
+	if err := r.revokeToken("b", time.Now().Add(-time.Minute).Unix()); err != nil {
+		t.Fatalf("revokeToken errored with: %v", err)
+	}
+	if m.Exists("revoked:b") {
+		t.Errorf("revokeToken stored an already expired token")
+	}
+}
@@ -0,0 +1,277 @@
+package pogifyapi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	gonanoid "github.com/matoous/go-nanoid"
+)
+
+// redisChannelPrefix namespaces pubsub channels from anything else on the
+// redis server
+const redisChannelPrefix = "pubsub:"
+
+// redisSubscribersPrefix namespaces the subscriber counts each instance keeps
+// for a channel
+const redisSubscribersPrefix = "subscribers:"
+
+// an instance's subscriber counts expire after redisSubscribersTTL unless
+// refreshed, so counts from instances that went away don't linger
+const (
+	redisSubscribersTTL       = 30 * time.Second
+	redisSubscribersHeartbeat = 10 * time.Second
+)
+
+// redisSubscribeTimeout is how long Subscribe waits for redis to confirm a
+// new channel
+const redisSubscribeTimeout = 5 * time.Second
+
+// redisPubSub publishes over Redis Pub/Sub so that every instance sharing the
+// redis server can fan out to its own subscribers. Each instance subscribes
+// once per channel on a single connection and fans out locally.
+type redisPubSub struct {
+	conn     *redis.Client
+	local    *localBroker
+	ps       *redis.PubSub
+	instance string
+
+	mu sync.Mutex
+	// channels counts this instance's subscribers per channel
+	channels map[string]int64
+	// confirmed is closed once redis confirms the subscription to a channel
+	confirmed map[string]chan struct{}
+	stop      chan struct{}
+	closed    bool
+}
+
+func newRedisPubSub(r *r) (Publisher, error) {
+	instance, err := gonanoid.ID(21)
+	if err != nil {
+		return nil, err
+	}
+	local, _ := newLocalBroker(r)
+
+	p := &redisPubSub{
+		conn:      r.conn,
+		local:     local.(*localBroker),
+		ps:        r.conn.Subscribe(ctx),
+		instance:  instance,
+		channels:  make(map[string]int64),
+		confirmed: make(map[string]chan struct{}),
+		stop:      make(chan struct{}),
+	}
+	go p.receive()
+	go p.heartbeat()
+
+	return p, nil
+}
+
+// receive fans messages out to this instance's subscribers
+func (p *redisPubSub) receive() {
+	for msg := range p.ps.ChannelWithSubscriptions(ctx, localBrokerBuffer) {
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind != "subscribe" {
+				continue
+			}
+			p.mu.Lock()
+			if ready, ok := p.confirmed[strings.TrimPrefix(msg.Channel, redisChannelPrefix)]; ok {
+				select {
+				case <-ready:
+				default:
+					close(ready)
+				}
+			}
+			p.mu.Unlock()
+		case *redis.Message:
+			p.local.Publish(strings.TrimPrefix(msg.Channel, redisChannelPrefix), []byte(msg.Payload))
+		}
+	}
+}
+
+// heartbeat keeps this instance's subscriber counts from expiring
+func (p *redisPubSub) heartbeat() {
+	ticker := time.NewTicker(redisSubscribersHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			counts := make(map[string]int64, len(p.channels))
+			for channel, n := range p.channels {
+				counts[channel] = n
+			}
+			p.mu.Unlock()
+
+			for channel, n := range counts {
+				if err := p.setSubscribers(channel, n); err != nil {
+					log.Printf("Pubsub error with: %v", err)
+				}
+			}
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+var setSubscribersScript = `
+	if (tonumber(ARGV[2]) > 0) then
+		redis.call("set", KEYS[2], ARGV[2], "ex", ARGV[3])
+		redis.call("sadd", KEYS[1], ARGV[1])
+		redis.call("expire", KEYS[1], ARGV[3])
+	else
+		redis.call("del", KEYS[2])
+		redis.call("srem", KEYS[1], ARGV[1])
+	end
+	return 1`
+
+// setSubscribers records that this instance has n subscribers on channel
+func (p *redisPubSub) setSubscribers(channel string, n int64) error {
+	key := redisSubscribersPrefix + channel
+	keys := []string{key, fmt.Sprintf("%v:%v", key, p.instance)}
+	return p.conn.Eval(ctx, setSubscribersScript, keys, p.instance, n, int64(redisSubscribersTTL.Seconds())).Err()
+}
+
+// sums the counts of instances with subscribers on KEYS[1] and forgets the
+// ones whose counts expired. With ARGV[1] it publishes to KEYS[2] first.
+var subscribersScript = `
+	if (ARGV[1]) then
+		redis.call("publish", KEYS[2], ARGV[1])
+	end
+	local n = 0
+	for _, instance in ipairs(redis.call("smembers", KEYS[1])) do
+		local c = redis.call("get", KEYS[1] .. ":" .. instance)
+		if (c) then
+			n = n + tonumber(c)
+		else
+			redis.call("srem", KEYS[1], instance)
+		end
+	end
+	return n`
+
+func (p *redisPubSub) Publish(channel string, data []byte) (int64, error) {
+	keys := []string{redisSubscribersPrefix + channel, redisChannelPrefix + channel}
+	return p.conn.Eval(ctx, subscribersScript, keys, data).Int64()
+}
+
+// Subscribers returns the subscribers on channel across every instance
+func (p *redisPubSub) Subscribers(channel string) (int64, error) {
+	keys := []string{redisSubscribersPrefix + channel, redisChannelPrefix + channel}
+	return p.conn.Eval(ctx, subscribersScript, keys).Int64()
+}
+
+func (p *redisPubSub) Active(channel string) (bool, error) {
+	n, err := p.Subscribers(channel)
+	return n > 0, err
+}
+
+// Subscribe subscribes this instance to channel on redis if it isn't already
+// and waits for the subscription so no message published after it returns is
+// missed
+func (p *redisPubSub) Subscribe(channel string) (*Subscription, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errors.New("pubsub closed")
+	}
+	sub, _ := p.local.Subscribe(channel)
+	p.channels[channel]++
+	n := p.channels[channel]
+	ready, ok := p.confirmed[channel]
+	if !ok {
+		ready = make(chan struct{})
+		p.confirmed[channel] = ready
+		if err := p.ps.Subscribe(ctx, redisChannelPrefix+channel); err != nil {
+			p.unsubscribe(channel)
+			p.mu.Unlock()
+			sub.Close()
+			return nil, err
+		}
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-ready:
+	case <-time.After(redisSubscribeTimeout):
+		p.mu.Lock()
+		p.unsubscribe(channel)
+		p.mu.Unlock()
+		sub.Close()
+		return nil, errors.New("redis didn't confirm the subscription")
+	}
+
+	if err := p.setSubscribers(channel, n); err != nil {
+		log.Printf("Pubsub error with: %v", err)
+	}
+
+	return &Subscription{
+		C: sub.C,
+		unsubscribe: func() {
+			sub.Close()
+
+			p.mu.Lock()
+			n := p.unsubscribe(channel)
+			closed := p.closed
+			p.mu.Unlock()
+
+			if !closed {
+				if err := p.setSubscribers(channel, n); err != nil {
+					log.Printf("Pubsub error with: %v", err)
+				}
+			}
+		},
+	}, nil
+}
+
+// unsubscribe drops one of this instance's subscribers on channel and returns
+// how many are left. The last one unsubscribes from redis. Must be called with
+// mu held.
+func (p *redisPubSub) unsubscribe(channel string) int64 {
+	if p.channels[channel] == 0 {
+		return 0
+	}
+	p.channels[channel]--
+	n := p.channels[channel]
+	if n == 0 {
+		delete(p.channels, channel)
+		delete(p.confirmed, channel)
+		if !p.closed {
+			if err := p.ps.Unsubscribe(ctx, redisChannelPrefix+channel); err != nil {
+				log.Printf("Pubsub error with: %v", err)
+			}
+		}
+	}
+	return n
+}
+
+// Close closes open subscriptions and clears this instance's subscriber
+// counts. The redis client is shared with the server and left open.
+func (p *redisPubSub) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	channels := p.channels
+	p.channels = make(map[string]int64)
+	p.confirmed = make(map[string]chan struct{})
+	p.mu.Unlock()
+
+	close(p.stop)
+	for channel := range channels {
+		if err := p.setSubscribers(channel, 0); err != nil {
+			log.Printf("Pubsub error with: %v", err)
+		}
+	}
+
+	err := p.ps.Close()
+	p.local.Close()
+	return err
+}
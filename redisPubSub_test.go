@@ -0,0 +1,146 @@
+package pogifyapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func Test_redisPubSub(t *testing.T) {
+	m, err := miniredis.Run()
+	defer m.Close()
+	if err != nil {
+		t.Fatalf("miniRedis errored: %v", err)
+	}
+
+	var r = new(r)
+	r.conn = redis.NewClient(&redis.Options{
+		Addr: m.Addr(),
+	})
+
+	p, _ := newRedisPubSub(r)
+	ps := p.(*redisPubSub)
+
+	if n, err := ps.Subscribers("test"); err != nil || n != 0 {
+		t.Errorf("Subscribers on empty channel returned %v, %v", n, err)
+	}
+
+	sub1, err := ps.Subscribe("test")
+	if err != nil {
+		t.Fatalf("Subscribe errored with: %v", err)
+	}
+	sub2, _ := ps.Subscribe("test")
+
+	if n, _ := ps.Subscribers("test"); n != 2 {
+		t.Errorf("Subscribers returned %v, expected %v", n, 2)
+	}
+
+	if channels := m.PubSubChannels(""); len(channels) != 1 || channels[0] != redisChannelPrefix+"test" {
+		t.Errorf("subscribed to unexpected redis channels: %v", channels)
+	}
+	if n := m.PubSubNumSub(redisChannelPrefix + "test")[redisChannelPrefix+"test"]; n != 1 {
+		t.Errorf("instance subscribed on %v redis connections, expected %v", n, 1)
+	}
+
+	n, err := ps.Publish("test", []byte("hello"))
+	if err != nil {
+		t.Fatalf("Publish errored with: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Publish delivered to %v, expected %v", n, 2)
+	}
+
+	for _, sub := range []*Subscription{sub1, sub2} {
+		select {
+		case msg := <-sub.C:
+			if string(msg) != "hello" {
+				t.Errorf("subscriber got %v, expected %v", string(msg), "hello")
+			}
+		case <-time.After(time.Second):
+			t.Error("subscriber didn't receive message")
+		}
+	}
+
+	t.Run("other instances", func(t *testing.T) {
+		other, _ := newRedisPubSub(r)
+		defer other.Close()
+
+		sub, err := other.(*redisPubSub).Subscribe("test")
+		if err != nil {
+			t.Fatalf("Subscribe errored with: %v", err)
+		}
+		defer sub.Close()
+
+		if n, _ := ps.Subscribers("test"); n != 3 {
+			t.Errorf("Subscribers returned %v, expected %v", n, 3)
+		}
+		if n, _ := ps.Publish("test", []byte("everyone")); n != 3 {
+			t.Errorf("Publish delivered to %v, expected %v", n, 3)
+		}
+		select {
+		case msg := <-sub.C:
+			if string(msg) != "everyone" {
+				t.Errorf("subscriber got %v, expected %v", string(msg), "everyone")
+			}
+		case <-time.After(time.Second):
+			t.Error("subscriber on another instance didn't receive message")
+		}
+		<-sub1.C
+		<-sub2.C
+	})
+
+	t.Run("instance that went away", func(t *testing.T) {
+		m.SAdd(redisSubscribersPrefix+"test", "gone")
+		m.Set(redisSubscribersPrefix+"test:gone", "5")
+		m.SetTTL(redisSubscribersPrefix+"test:gone", time.Second)
+		if n, _ := ps.Subscribers("test"); n != 7 {
+			t.Errorf("Subscribers returned %v, expected %v", n, 7)
+		}
+
+		m.FastForward(2 * time.Second)
+		if n, _ := ps.Subscribers("test"); n != 2 {
+			t.Errorf("Subscribers returned %v after the instance's count expired, expected %v", n, 2)
+		}
+		if ok, _ := m.SIsMember(redisSubscribersPrefix+"test", "gone"); ok {
+			t.Error("Subscribers didn't forget the expired instance")
+		}
+	})
+
+	sub1.Close()
+	select {
+	case _, ok := <-sub1.C:
+		if ok {
+			t.Error("closed subscription received a message")
+		}
+	case <-time.After(time.Second):
+		t.Error("Close didn't close subscription channel")
+	}
+
+	if n, _ := ps.Subscribers("test"); n != 1 {
+		t.Errorf("Subscribers returned %v after Close, expected %v", n, 1)
+	}
+
+	sub2.Close()
+	// redis handles the unsubscribe asynchronously
+	channels := m.PubSubChannels("")
+	for i := 0; i < 100 && len(channels) != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		channels = m.PubSubChannels("")
+	}
+	if len(channels) != 0 {
+		t.Errorf("still subscribed to %v without subscribers", channels)
+	}
+
+	sub3, _ := ps.Subscribe("test")
+	ps.Close()
+	select {
+	case <-sub3.C:
+	case <-time.After(time.Second):
+		t.Error("publisher Close didn't close subscriptions")
+	}
+	if n, _ := ps.Subscribers("test"); n != 0 {
+		t.Errorf("Subscribers returned %v after publisher Close, expected %v", n, 0)
+	}
+}
@@ -1,6 +1,9 @@
 package pogifyapi
 
 import (
+	"encoding/json"
+	"log"
+	"net/http"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -8,57 +11,146 @@ import (
 	gonanoid "github.com/matoous/go-nanoid"
 )
 
+// sessionRevokedEvent tells the host its session was ended because a refresh
+// token was reused
+const sessionRevokedEvent = "revoked"
+
+// maxRefreshSize is the largest refresh body accepted
+const maxRefreshSize = 4 << 10
+
+type refreshRequest struct {
+	SessionToken string `json:"sessionToken"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// readRefreshRequest reads the tokens from the JSON body and X-Session-Token
+// header, or from the deprecated query string if allowed
+func (s *server) readRefreshRequest(c *gin.Context) (refreshRequest, error) {
+	var req refreshRequest
+	if c.Request.Body != nil && c.Request.ContentLength != 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRefreshSize)
+		if err := c.ShouldBindJSON(&req); err != nil {
+			return req, err
+		}
+	}
+
+	if sessionToken := c.GetHeader("X-Session-Token"); sessionToken != "" {
+		req.SessionToken = sessionToken
+	}
+
+	// query strings end up in access logs
+	if s.refreshQuery && req.SessionToken == "" && req.RefreshToken == "" {
+		req.SessionToken = c.Query("sessionToken")
+		req.RefreshToken = c.Query("refreshToken")
+		if req.SessionToken != "" || req.RefreshToken != "" {
+			c.Header("Deprecation", "true")
+		}
+	}
+
+	return req, nil
+}
+
 // RefreshSession ...
 func (s *server) refreshSession(c *gin.Context) {
-	sessionJWT := c.Query("sessionToken")
-	if sessionJWT == "" {
-		c.String(400, "missing query: sessionToken")
+	req, err := s.readRefreshRequest(c)
+	if err != nil {
+		c.Error(err)
+		jsonError(c, 400, "invalid_body", err.Error())
 		return
 	}
 
-	refreshToken := c.Query("refreshToken")
-	if refreshToken == "" {
-		c.String(400, "missing query: refreshToken")
+	if req.SessionToken == "" {
+		jsonError(c, 400, "missing_session_token", "missing X-Session-Token header")
 		return
 	}
 
-	token, err := jwt.Parse(sessionJWT, func(token *jwt.Token) (interface{}, error) {
-		return s.jwt.secret, nil
-	})
+	if req.RefreshToken == "" {
+		jsonError(c, 400, "missing_refresh_token", "missing refreshToken")
+		return
+	}
 
-	if ve, ok := err.(*jwt.ValidationError); ok {
-		if ve.Errors&jwt.ValidationErrorExpired == 0 {
-			c.AbortWithError(400, err)
-			return
-		}
+	// expired tokens can be refreshed, but only if being expired is all
+	// that's wrong with them
+	oldClaims, err := s.parseSessionToken(req.SessionToken)
+	if ve, ok := err.(*jwt.ValidationError); err != nil && (!ok || ve.Errors != jwt.ValidationErrorExpired) {
+		c.Error(err)
+		jsonError(c, 400, "invalid_session_token", err.Error())
+		return
+	}
+
+	if oldClaims.Role != "" {
+		jsonError(c, 400, "invalid_session_token", "not a host token")
+		return
 	}
 
-	sessionID := token.Claims.(jwt.MapClaims)["session"].(string)
+	sessionID := oldClaims.Session
+
+	if ended, err := s.redis.sessionEnded(sessionID, oldClaims.IssuedAt); err != nil {
+		c.AbortWithError(500, err)
+		return
+	} else if ended {
+		jsonError(c, 401, "token_revoked", "token revoked")
+		return
+	}
 
 	newRefreshToken, err := gonanoid.ID(64)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
 
-	val, err := s.redis.verifyAndSetNewRefreshToken(sessionID, refreshToken, newRefreshToken)
+	// a revoked session token was either rotated or revoked by the host;
+	// only a reused refresh token says the session's tokens were stolen
+	if oldClaims.Id != "" {
+		if revoked, err := s.redis.tokenRevoked(oldClaims.Id); err != nil {
+			c.AbortWithError(500, err)
+			return
+		} else if revoked {
+			jsonError(c, 401, "token_revoked", "token revoked")
+			return
+		}
+	}
+
+	val, err := s.redis.verifyAndSetNewRefreshToken(sessionID, req.RefreshToken, newRefreshToken)
 	if err != nil {
 		c.AbortWithError(500, err)
 		return
 	}
 
 	switch val {
+	case -2:
+		// either the host or whoever stole its tokens refreshed already; we
+		// can't tell who is who, so neither keeps the session
+		seq, err := s.terminateSession(sessionID)
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+
+		msg, _ := json.Marshal(envelope{
+			Seq:  seq,
+			Time: MilliTime(time.Now()),
+			Type: sessionRevokedEvent,
+		})
+		if _, err := s.pubsub.Publish("host_"+sessionID, msg); err != nil {
+			log.Printf("Pubsub error with: %v", err)
+		}
+
+		jsonError(c, 401, "refresh_token_reused", "refresh token reused")
 	case -1:
-		c.String(400, "refresh token expired")
+		jsonError(c, 400, "refresh_token_expired", "refresh token expired")
 	case 0:
-		c.String(400, "invalid refreshToken")
+		jsonError(c, 400, "invalid_refresh_token", "invalid refreshToken")
 	case 1:
-		claims := sessionJwtClaims{
-			sessionID,
-			jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(time.Hour).Unix(),
-			},
+		// the old token is replaced by this one
+		if oldClaims.Id != "" {
+			if err := s.redis.revokeToken(oldClaims.Id, oldClaims.ExpiresAt); err != nil {
+				c.AbortWithError(500, err)
+				return
+			}
 		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-		tokenSign, err := token.SignedString(s.jwt.secret)
+		tokenSign, err := s.newSessionToken(sessionID)
 		if err != nil {
 			c.AbortWithError(500, err)
 			return
@@ -68,7 +160,7 @@ func (s *server) refreshSession(c *gin.Context) {
 			"session":      sessionID,
 			"refreshToken": newRefreshToken,
 			"token":        tokenSign,
-			"expiresIn":    time.Hour.Seconds(),
+			"expiresIn":    s.jwt.ttl.Seconds(),
 		})
 	}
 
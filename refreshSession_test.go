@@ -2,10 +2,12 @@ package pogifyapi
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -18,9 +20,12 @@ import (
 func Test_server_refreshSession(t *testing.T) {
 	sessionCode, _ := gonanoid.ID(10)
 	claims := sessionJwtClaims{
-		sessionCode,
-		jwt.StandardClaims{
+		Session: sessionCode,
+		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Issuer:    defaultJWTIssuer,
+			Audience:  defaultJWTAudience,
 		},
 	}
 
@@ -144,5 +149,214 @@ func Test_server_refreshSession(t *testing.T) {
 			t.Errorf("refreshToken returned different refeshTokens. Got: %v, Expected: %v", j["refreshToken"].(string), newRefreshToken)
 		}
 	})
+	post := func(router *gin.Engine, header string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/refresh", strings.NewReader(body))
+		req.Header.Add("Content-Type", "application/json")
+		if header != "" {
+			req.Header.Add("X-Session-Token", header)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	t.Run("with X-Session-Token, with refreshToken in body", func(t *testing.T) {
+		mr.Set("session:"+sessionCode, "abc")
+		w := post(router, mockToken, `{"refreshToken":"abc"}`)
+
+		if w.Code != 200 {
+			t.Fatalf("proper call to refreshSession didn't return 200, instead: %v", w.Code)
+		}
+		if w.Header().Get("Deprecation") != "" {
+			t.Errorf("refreshSession set Deprecation header on a body call")
+		}
+
+		var j map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &j)
+
+		newRefreshToken, _ := mr.Get("session:" + sessionCode)
+		if j["refreshToken"] != newRefreshToken {
+			t.Errorf("refreshToken returned different refeshTokens. Got: %v, Expected: %v", j["refreshToken"], newRefreshToken)
+		}
+	})
+	t.Run("with both tokens in body", func(t *testing.T) {
+		mr.Set("session:"+sessionCode, "abc")
+		w := post(router, "", fmt.Sprintf(`{"sessionToken":%q,"refreshToken":"abc"}`, mockToken))
+
+		if w.Code != 200 {
+			t.Errorf("proper call to refreshSession didn't return 200, instead: %v", w.Code)
+		}
+	})
+	t.Run("errors are json", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			header string
+			body   string
+			code   int
+			err    string
+		}{
+			{"invalid body", mockToken, `{"refreshToken":`, 400, "invalid_body"},
+			{"missing sessionToken", "", `{"refreshToken":"abc"}`, 400, "missing_session_token"},
+			{"missing refreshToken", mockToken, `{}`, 400, "missing_refresh_token"},
+			{"invalid sessionToken", "aaaa", `{"refreshToken":"abc"}`, 400, "invalid_session_token"},
+			{"invalid refreshToken", mockToken, `{"refreshToken":"aaaaa"}`, 400, "invalid_refresh_token"},
+		}
+		for _, tt := range tests {
+			mr.Set("session:"+sessionCode, "abc")
+			w := post(router, tt.header, tt.body)
+
+			var j map[string]string
+			json.Unmarshal(w.Body.Bytes(), &j)
+			if w.Code != tt.code || j["error"] != tt.err || j["message"] == "" {
+				t.Errorf("%v: refreshSession returned %v %v, expected %v with error %v", tt.name, w.Code, w.Body.String(), tt.code, tt.err)
+			}
+		}
+	})
+	t.Run("query is deprecated", func(t *testing.T) {
+		mr.Set("session:"+sessionCode, "abc")
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/refresh", nil)
+		q := req.URL.Query()
+		q.Add("sessionToken", mockToken)
+		q.Add("refreshToken", "abc")
+
+		req.URL.RawQuery = q.Encode()
+		router.ServeHTTP(w, req)
+
+		if w.Code != 200 {
+			t.Errorf("proper call to refreshSession didn't return 200, instead: %v", w.Code)
+		}
+		if w.Header().Get("Deprecation") != "true" {
+			t.Errorf("refreshSession didn't set Deprecation header on a query call")
+		}
+	})
+	t.Run("without query, with SESSION_TOKEN_TTL", func(t *testing.T) {
+		os.Setenv("ALLOW_REFRESH_QUERY", "false")
+		os.Setenv("SESSION_TOKEN_TTL", "120")
+		defer os.Unsetenv("ALLOW_REFRESH_QUERY")
+		defer os.Unsetenv("SESSION_TOKEN_TTL")
+
+		router := gin.New()
+		Server(router.Group("/"))
+
+		mr.Set("session:"+sessionCode, "abc")
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/refresh?sessionToken="+mockToken+"&refreshToken=abc", nil)
+		router.ServeHTTP(w, req)
 
+		if w.Code != 400 {
+			t.Errorf("query call to refreshSession with ALLOW_REFRESH_QUERY=false didn't return 400, instead: %v", w.Code)
+		}
+
+		w = post(router, mockToken, `{"refreshToken":"abc"}`)
+		if w.Code != 200 {
+			t.Fatalf("proper call to refreshSession didn't return 200, instead: %v", w.Code)
+		}
+
+		var j struct {
+			Token     string  `json:"token"`
+			ExpiresIn float64 `json:"expiresIn"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &j)
+		if j.ExpiresIn != 120 {
+			t.Errorf("refreshSession returned expiresIn %v, expected %v", j.ExpiresIn, 120)
+		}
+
+		claims := new(sessionJwtClaims)
+		jwt.ParseWithClaims(j.Token, claims, func(t *jwt.Token) (interface{}, error) {
+			return []byte(os.Getenv("JWT_SECRET")), nil
+		})
+		if ttl := claims.ExpiresAt - time.Now().Unix(); ttl < 119 || ttl > 120 {
+			t.Errorf("refreshSession issued a token expiring in %v, expected %v", ttl, 120)
+		}
+	})
+	refresh := func(sessionToken string, refreshToken string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/refresh", nil)
+		q := req.URL.Query()
+		q.Add("sessionToken", sessionToken)
+		q.Add("refreshToken", refreshToken)
+
+		req.URL.RawQuery = q.Encode()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	t.Run("rotated sessionToken is revoked", func(t *testing.T) {
+		rotated := claims
+		rotated.Id = "rotated"
+		rotatedToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, rotated).SignedString([]byte(os.Getenv("JWT_SECRET")))
+
+		mr.Set("session:"+sessionCode, "abc")
+		if code := refresh(rotatedToken, "abc"); code != 200 {
+			t.Fatalf("proper call to refreshSession didn't return 200, instead: %v", code)
+		}
+		if !mr.Exists("revoked:rotated") {
+			t.Errorf("refreshSession didn't revoke the old sessionToken")
+		}
+
+		// anyone holding an old sessionToken can't refresh, but can't end the
+		// session either
+		mr.Set("session:"+sessionCode, "abc")
+		if code := refresh(rotatedToken, "abc"); code != 401 {
+			t.Errorf("refreshSession with a revoked sessionToken didn't return 401, instead: %v", code)
+		}
+		if !mr.Exists("session:"+sessionCode) || mr.Exists("ended:"+sessionCode) {
+			t.Errorf("refreshSession with a revoked sessionToken ended the session")
+		}
+	})
+	t.Run("reused refreshToken ends the session", func(t *testing.T) {
+		reusedCode := sessionCode + "-reused"
+		reused := claims
+		reused.Session = reusedCode
+		reusedToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, reused).SignedString([]byte(os.Getenv("JWT_SECRET")))
+
+		mr.Set("session:"+reusedCode, "first")
+		if code := refresh(reusedToken, "first"); code != 200 {
+			t.Fatalf("proper call to refreshSession didn't return 200, instead: %v", code)
+		}
+		current, _ := mr.Get("session:" + reusedCode)
+
+		if code := refresh(reusedToken, "wrong"); code != 400 {
+			t.Errorf("call with invalid refreshToken to refreshSession didn't return 400, instead: %v", code)
+		}
+
+		if code := refresh(reusedToken, "first"); code != 401 {
+			t.Errorf("call with reused refreshToken to refreshSession didn't return 401, instead: %v", code)
+		}
+		if mr.Exists("session:"+reusedCode) || !mr.Exists("ended:"+reusedCode) {
+			t.Errorf("reused refreshToken didn't end the session")
+		}
+
+		e := unwrap(t, _fakePublisher.last("host_"+reusedCode))
+		if e.Type != sessionRevokedEvent {
+			t.Errorf("host was notified with %q, expected %q", e.Type, sessionRevokedEvent)
+		}
+		if e := unwrap(t, _fakePublisher.last(reusedCode)); e.Type != sessionEndedEvent {
+			t.Errorf("listeners were notified with %q, expected %q", e.Type, sessionEndedEvent)
+		}
+
+		// the current refresh token went with the session
+		if code := refresh(reusedToken, current); code != 401 {
+			t.Errorf("call after reuse to refreshSession didn't return 401, instead: %v", code)
+		}
+	})
+	t.Run("forged expired sessionToken", func(t *testing.T) {
+		victimCode := sessionCode + "-victim"
+		mr.Set("session:"+victimCode, "victim")
+		// the jti is revoked, like one from the attacker's own session
+		mr.Set("revoked:attacker", "1")
+
+		forged := claims
+		forged.Session = victimCode
+		forged.Id = "attacker"
+		forged.IssuedAt = time.Now().Add(-2 * time.Hour).Unix()
+		forged.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+		forgedToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, forged).SignedString([]byte("not the secret"))
+
+		if code := refresh(forgedToken, "anything"); code != 400 {
+			t.Errorf("refreshSession with a forged expired sessionToken returned %v, expected %v", code, 400)
+		}
+		if !mr.Exists("session:"+victimCode) || mr.Exists("ended:"+victimCode) {
+			t.Errorf("refreshSession with a forged expired sessionToken ended the session")
+		}
+	})
 }
@@ -0,0 +1,123 @@
+package pogifyapi
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statuses of a queued request
+const (
+	requestPending  = "pending"
+	requestAccepted = "accepted"
+	requestRejected = "rejected"
+)
+
+// queuedRequest is a song request as stored in the session's queue
+type queuedRequest struct {
+	ID   string      `json:"id"`
+	Song songRequest `json:"song"`
+	// Requester identifies who made the request without their provider subject
+	Requester string `json:"requester"`
+	// Time is when the request was made in unix milliseconds
+	Time   int64  `json:"time"`
+	Status string `json:"status"`
+	// Count is how many times the song was requested in the duplicate window
+	Count int64 `json:"count"`
+	// Votes is the listeners' up votes minus their down votes
+	Votes int64 `json:"votes"`
+}
+
+type requestStatusChange struct {
+	ID string `json:"id" binding:"required"`
+}
+
+// requesterID returns the requester for a listener identified by provider
+func requesterID(provider string, id string) string {
+	// listener IDs already carry their provider
+	if provider != providerListener {
+		id = provider + ":" + id
+	}
+	return fmt.Sprintf("%x", hashID(id))
+}
+
+// getRequests lists the session's queued requests, oldest first. The status
+// query filters them.
+func (s *server) getRequests(c *gin.Context) {
+	principal, err := getPrincipal(c)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	requests, err := s.redis.queuedRequests(principal.Session)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	if status := c.Query("status"); status != "" {
+		filtered := requests[:0]
+		for _, q := range requests {
+			if q.Status == status {
+				filtered = append(filtered, q)
+			}
+		}
+		requests = filtered
+	}
+
+	c.JSON(200, gin.H{"requests": requests})
+}
+
+// acceptRequest marks a queued request as accepted
+func (s *server) acceptRequest(c *gin.Context) {
+	s.setRequestStatus(c, requestAccepted)
+}
+
+// rejectRequest marks a queued request as rejected
+func (s *server) rejectRequest(c *gin.Context) {
+	s.setRequestStatus(c, requestRejected)
+}
+
+func (s *server) setRequestStatus(c *gin.Context, status string) {
+	principal, err := getPrincipal(c)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	var req requestStatusChange
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err)
+		c.String(400, fmt.Sprint(err))
+		return
+	}
+
+	updated, err := s.redis.setRequestStatus(principal.Session, req.ID, status)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	if !updated {
+		c.String(404, "no such request")
+		return
+	}
+
+	c.String(200, "ok")
+}
+
+// clearRequests empties the session's queue
+func (s *server) clearRequests(c *gin.Context) {
+	principal, err := getPrincipal(c)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	if err := s.redis.clearRequests(principal.Session); err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	c.String(200, "ok")
+}
@@ -0,0 +1,320 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_server_requestQueue(t *testing.T) {
+	signHost := func(session string) string {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, sessionJwtClaims{
+			Session: session,
+			StandardClaims: jwt.StandardClaims{
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+				IssuedAt:  time.Now().Unix(),
+				Issuer:    defaultJWTIssuer,
+				Audience:  defaultJWTAudience,
+			},
+		}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+		return token
+	}
+	hostToken := signHost("exist")
+
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+	mr.Set("session:exist", "refresh")
+	mr.Set("session:offline", "refresh")
+
+	router := gin.New()
+
+	Server(router.Group("/"))
+
+	send := func(method string, endpoint string, token string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, endpoint, strings.NewReader(body))
+		req.Header.Add("X-Session-Token", token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	// makeRequest requests song as a new anonymous listener
+	makeRequest := func(session string, song string) queuedRequest {
+		var l struct {
+			Token string `json:"token"`
+		}
+		json.Unmarshal(send("POST", "/session/listener", "", fmt.Sprintf(`{"session":%q}`, session)).Body.Bytes(), &l)
+
+		w := send("POST", "/session/request", "", fmt.Sprintf(`{"session":%q,"provider":"listener","token":%q,"request":%q}`, session, l.Token, song))
+		if w.Code != 200 {
+			t.Fatalf("makeRequest returned %v, expected %v: %v", w.Code, 200, w.Body.String())
+		}
+		var q queuedRequest
+		json.Unmarshal(w.Body.Bytes(), &q)
+		return q
+	}
+	list := func(token string, query string) []queuedRequest {
+		w := send("GET", "/session/requests"+query, token, "")
+		if w.Code != 200 {
+			t.Fatalf("getRequests returned %v, expected %v: %v", w.Code, 200, w.Body.String())
+		}
+		var res struct {
+			Requests []queuedRequest `json:"requests"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return res.Requests
+	}
+
+	first := makeRequest("exist", "spotify:track:4uLU6hMCjMI75M1A2tKUQC")
+	// requests in the same millisecond have no order
+	time.Sleep(2 * time.Millisecond)
+	second := makeRequest("exist", "https://youtu.be/dQw4w9WgXcQ")
+
+	t.Run("queued", func(t *testing.T) {
+		requests := list(hostToken, "")
+		if len(requests) != 2 || requests[0] != first || requests[1] != second {
+			t.Fatalf("getRequests returned %+v, expected %+v and %+v", requests, first, second)
+		}
+		if first.Status != requestPending || first.Requester == "" || first.Requester == second.Requester || first.Time == 0 {
+			t.Errorf("makeRequest queued %+v", first)
+		}
+		if mr.TTL("session:exist:requests") <= 0 || mr.TTL("session:exist:requestQueue") <= 0 {
+			t.Errorf("requests don't expire with the session")
+		}
+	})
+
+	t.Run("host reconnecting", func(t *testing.T) {
+		// nchan keeps the session's channel while its host reconnects
+		mr.Set("session:offline", "refresh")
+		_fakePublisher.Publish("offline", []byte("{}"))
+		q := makeRequest("offline", "spotify:track:4uLU6hMCjMI75M1A2tKUQC")
+		if requests := list(signHost("offline"), ""); len(requests) != 1 || requests[0] != q {
+			t.Errorf("getRequests returned %+v, expected %+v", requests, q)
+		}
+	})
+
+	t.Run("accept and reject", func(t *testing.T) {
+		if w := send("POST", "/session/requests/accept", hostToken, fmt.Sprintf(`{"id":%q}`, first.ID)); w.Code != 200 {
+			t.Errorf("acceptRequest returned %v, expected %v", w.Code, 200)
+		}
+		if w := send("POST", "/session/requests/reject", hostToken, fmt.Sprintf(`{"id":%q}`, second.ID)); w.Code != 200 {
+			t.Errorf("rejectRequest returned %v, expected %v", w.Code, 200)
+		}
+		if w := send("POST", "/session/requests/accept", hostToken, `{"id":"nope"}`); w.Code != 404 {
+			t.Errorf("acceptRequest for an unknown request returned %v, expected %v", w.Code, 404)
+		}
+		if w := send("POST", "/session/requests/accept", hostToken, `{}`); w.Code != 400 {
+			t.Errorf("acceptRequest without id returned %v, expected %v", w.Code, 400)
+		}
+
+		accepted := list(hostToken, "?status="+requestAccepted)
+		if len(accepted) != 1 || accepted[0].ID != first.ID || accepted[0].Song != first.Song {
+			t.Errorf("accepted requests are %+v", accepted)
+		}
+		rejected := list(hostToken, "?status="+requestRejected)
+		if len(rejected) != 1 || rejected[0].ID != second.ID {
+			t.Errorf("rejected requests are %+v", rejected)
+		}
+	})
+
+	t.Run("delegates", func(t *testing.T) {
+		var requests, update struct {
+			Token string `json:"token"`
+		}
+		json.Unmarshal(send("POST", "/session/delegates", hostToken, `{"scopes":["requests"]}`).Body.Bytes(), &requests)
+		json.Unmarshal(send("POST", "/session/delegates", hostToken, `{"scopes":["update"]}`).Body.Bytes(), &update)
+
+		if got := list(requests.Token, ""); len(got) != 2 {
+			t.Errorf("getRequests as a delegate returned %+v", got)
+		}
+		if w := send("GET", "/session/requests", update.Token, ""); w.Code != 403 {
+			t.Errorf("getRequests without requests scope returned %v, expected %v", w.Code, 403)
+		}
+		if w := send("GET", "/session/requests", "", ""); w.Code != 400 {
+			t.Errorf("getRequests without a token returned %v, expected %v", w.Code, 400)
+		}
+	})
+
+	t.Run("clear", func(t *testing.T) {
+		if w := send("DELETE", "/session/requests", hostToken, ""); w.Code != 200 {
+			t.Errorf("clearRequests returned %v, expected %v", w.Code, 200)
+		}
+		if requests := list(hostToken, ""); len(requests) != 0 {
+			t.Errorf("getRequests after clearRequests returned %+v", requests)
+		}
+	})
+}
+
+func Test_server_duplicateRequests(t *testing.T) {
+	hostToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, sessionJwtClaims{
+		Session: "exist",
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Issuer:    defaultJWTIssuer,
+			Audience:  defaultJWTAudience,
+		},
+	}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+	mr.Set("session:exist", "refresh")
+	mr.Set("session:offline", "refresh")
+
+	router := gin.New()
+
+	Server(router.Group("/"))
+
+	send := func(method string, endpoint string, token string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, endpoint, strings.NewReader(body))
+		req.Header.Add("X-Session-Token", token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	setConfig := func(body string) {
+		if w := send("POST", "/session/config", hostToken, body); w.Code != 200 {
+			t.Fatalf("setConfig returned %v, expected %v: %v", w.Code, 200, w.Body.String())
+		}
+	}
+	listener := func() string {
+		var l struct {
+			Token string `json:"token"`
+		}
+		json.Unmarshal(send("POST", "/session/listener", "", `{"session":"exist"}`).Body.Bytes(), &l)
+		return l.Token
+	}
+	requestAs := func(token string, song string) *httptest.ResponseRecorder {
+		return send("POST", "/session/request", "", fmt.Sprintf(`{"session":"exist","provider":"listener","token":%q,"request":%q}`, token, song))
+	}
+	// makeRequest requests song as a new anonymous listener
+	makeRequest := func(song string) *httptest.ResponseRecorder {
+		return requestAs(listener(), song)
+	}
+	queued := func(w *httptest.ResponseRecorder) queuedRequest {
+		if w.Code != 200 {
+			t.Fatalf("makeRequest returned %v, expected %v: %v", w.Code, 200, w.Body.String())
+		}
+		var q queuedRequest
+		json.Unmarshal(w.Body.Bytes(), &q)
+		return q
+	}
+
+	t.Run("allowed without a window", func(t *testing.T) {
+		first := queued(makeRequest("spotify:track:4uLU6hMCjMI75M1A2tKUQC"))
+		second := queued(makeRequest("spotify:track:4uLU6hMCjMI75M1A2tKUQC"))
+		if first.ID == second.ID || second.Count != 1 {
+			t.Errorf("makeRequest merged %+v into %+v", second, first)
+		}
+	})
+
+	t.Run("invalid policy", func(t *testing.T) {
+		if w := send("POST", "/session/config", hostToken, `{"requestInterval":10,"duplicateWindow":60,"duplicatePolicy":"ignore"}`); w.Code != 400 {
+			t.Errorf("setConfig with invalid policy returned %v, expected %v", w.Code, 400)
+		}
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		setConfig(`{"requestInterval":10,"duplicateWindow":60}`)
+
+		first := queued(makeRequest("https://youtu.be/dQw4w9WgXcQ"))
+		merged := queued(makeRequest("https://www.youtube.com/watch?v=dQw4w9WgXcQ"))
+		if merged.ID != first.ID || merged.Count != 2 || merged.Requester != first.Requester {
+			t.Errorf("makeRequest returned %+v, expected %+v merged", merged, first)
+		}
+
+		var host queuedRequest
+		json.Unmarshal(_fakePublisher.last("host_exist"), &host)
+		if host != merged {
+			t.Errorf("host got %+v, expected %+v", host, merged)
+		}
+
+		var list struct {
+			Requests []queuedRequest `json:"requests"`
+		}
+		json.Unmarshal(send("GET", "/session/requests", hostToken, "").Body.Bytes(), &list)
+		count := 0
+		for _, q := range list.Requests {
+			if q.ID == first.ID {
+				count = int(q.Count)
+			} else if q.Song == first.Song {
+				t.Errorf("duplicate %+v was queued", q)
+			}
+		}
+		if count != 2 {
+			t.Errorf("queued request has count %v, expected %v", count, 2)
+		}
+	})
+
+	t.Run("merge counts requesters once", func(t *testing.T) {
+		setConfig(`{"requestInterval":10,"duplicateWindow":60}`)
+
+		token := listener()
+		first := queued(requestAs(token, "spotify:track:7GhIk7Il098yCjg4BQjzvb"))
+		// past the request interval, still in the window
+		mr.FastForward(11 * time.Second)
+		again := queued(requestAs(token, "spotify:track:7GhIk7Il098yCjg4BQjzvb"))
+		if again.ID != first.ID || again.Count != 1 {
+			t.Errorf("makeRequest returned %+v, expected %+v with the same count", again, first)
+		}
+		if merged := queued(makeRequest("spotify:track:7GhIk7Il098yCjg4BQjzvb")); merged.Count != 2 {
+			t.Errorf("makeRequest returned count %v, expected %v", merged.Count, 2)
+		}
+	})
+
+	t.Run("merge only into pending requests", func(t *testing.T) {
+		setConfig(`{"requestInterval":10,"duplicateWindow":60}`)
+
+		first := queued(makeRequest("spotify:track:0VjIjW4GlUZAMYd2vXMi3b"))
+		if w := send("POST", "/session/requests/accept", hostToken, fmt.Sprintf(`{"id":%q}`, first.ID)); w.Code != 200 {
+			t.Fatalf("acceptRequest returned %v, expected %v", w.Code, 200)
+		}
+
+		again := queued(makeRequest("spotify:track:0VjIjW4GlUZAMYd2vXMi3b"))
+		if again.ID == first.ID || again.Status != requestPending || again.Count != 1 {
+			t.Errorf("makeRequest merged %+v into an accepted request", again)
+		}
+		// later duplicates go to the new request
+		if merged := queued(makeRequest("spotify:track:0VjIjW4GlUZAMYd2vXMi3b")); merged.ID != again.ID || merged.Count != 2 {
+			t.Errorf("makeRequest returned %+v, expected %+v merged", merged, again)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		setConfig(`{"requestInterval":10,"duplicateWindow":60,"duplicatePolicy":"reject"}`)
+
+		first := queued(makeRequest("spotify:album:1DFixLWuPkv3KT3TnV35m3"))
+		w := makeRequest("https://open.spotify.com/album/1DFixLWuPkv3KT3TnV35m3")
+		var res struct {
+			Error     string        `json:"error"`
+			Requester string        `json:"requester"`
+			Request   queuedRequest `json:"request"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		if w.Code != 409 || res.Error != "duplicate_request" || res.Requester != first.Requester || res.Request.ID != first.ID {
+			t.Errorf("makeRequest returned %v: %v", w.Code, w.Body.String())
+		}
+
+		// the window is over
+		mr.FastForward(61 * time.Second)
+		if again := queued(makeRequest("spotify:album:1DFixLWuPkv3KT3TnV35m3")); again.ID == first.ID {
+			t.Errorf("makeRequest rejected a request after the window")
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package pogifyapi
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type revokeRequest struct {
+	ID string `json:"jti" binding:"required,max=64"`
+}
+
+// revokeToken rejects a leaked host or delegate token of the session by its
+// jti until the token expires
+func (s *server) revokeToken(c *gin.Context) {
+	principal, err := getPrincipal(c)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	var req revokeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err)
+		c.String(400, fmt.Sprint(err))
+		return
+	}
+
+	// host and listener tokens aren't tracked, but none outlives maxTokenTTL
+	expiresAt := time.Now().Add(s.maxTokenTTL()).Unix()
+	d, err := s.redis.delegate(principal.Session, req.ID)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	if d != nil {
+		expiresAt = d.ExpiresAt
+		if _, err := s.redis.removeDelegate(principal.Session, req.ID); err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+	}
+
+	if err := s.redis.revokeToken(req.ID, expiresAt); err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"jti":       req.ID,
+		"expiresAt": expiresAt,
+	})
+}
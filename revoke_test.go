@@ -0,0 +1,111 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_server_revokeToken(t *testing.T) {
+	signHost := func(jti string) string {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, sessionJwtClaims{
+			Session: "test",
+			StandardClaims: jwt.StandardClaims{
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+				IssuedAt:  time.Now().Unix(),
+				Issuer:    defaultJWTIssuer,
+				Audience:  defaultJWTAudience,
+				Id:        jti,
+			},
+		}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+		return token
+	}
+	hostToken := signHost("host")
+	leakedToken := signHost("leaked")
+
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+	mr.Set("session:test", "refresh")
+
+	router := gin.New()
+
+	Server(router.Group("/"))
+
+	send := func(method string, endpoint string, token string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, endpoint, strings.NewReader(body))
+		req.Header.Add("X-Session-Token", token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		if w := send("POST", "/session/revoke", hostToken, `{}`); w.Code != 400 {
+			t.Errorf("revokeToken without jti returned %v, expected %v", w.Code, 400)
+		}
+		if w := send("POST", "/session/revoke", "", `{"jti":"leaked"}`); w.Code != 400 {
+			t.Errorf("revokeToken without a token returned %v, expected %v", w.Code, 400)
+		}
+	})
+
+	t.Run("host token", func(t *testing.T) {
+		if w := send("POST", "/session/revoke", hostToken, `{"jti":"leaked"}`); w.Code != 200 {
+			t.Fatalf("revokeToken returned %v, expected %v: %v", w.Code, 200, w.Body.String())
+		}
+		if w := send("GET", "/session/delegates", leakedToken, ""); w.Code != 401 {
+			t.Errorf("revoked token returned %v, expected %v", w.Code, 401)
+		}
+		if w := send("GET", "/session/delegates", hostToken, ""); w.Code != 200 {
+			t.Errorf("revoking another token revoked the host's with %v", w.Code)
+		}
+		if ttl := mr.TTL("revoked:leaked"); ttl <= 0 || ttl > time.Hour {
+			t.Errorf("revoked marker has ttl %v, expected at most the session token TTL", ttl)
+		}
+	})
+
+	t.Run("delegate token", func(t *testing.T) {
+		var res struct {
+			Delegate delegate `json:"delegate"`
+			Token    string   `json:"token"`
+		}
+		json.Unmarshal(send("POST", "/session/delegates", hostToken, `{"scopes":["update"],"expiresIn":120}`).Body.Bytes(), &res)
+
+		w := send("POST", "/session/revoke", hostToken, fmt.Sprintf(`{"jti":%q}`, res.Delegate.ID))
+		var revoked struct {
+			ExpiresAt int64 `json:"expiresAt"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &revoked)
+		if w.Code != 200 || revoked.ExpiresAt != res.Delegate.ExpiresAt {
+			t.Fatalf("revokeToken returned %v: %v, expected the delegate's expiry %v", w.Code, w.Body.String(), res.Delegate.ExpiresAt)
+		}
+		if w := send("POST", "/session/update", res.Token, testUpdate("spotify:track:a")); w.Code != 401 {
+			t.Errorf("revoked delegate returned %v, expected %v", w.Code, 401)
+		}
+		if ttl := mr.TTL("revoked:" + res.Delegate.ID); ttl <= 0 || ttl > 2*time.Minute {
+			t.Errorf("revoked marker has ttl %v, expected the delegate's", ttl)
+		}
+	})
+
+	t.Run("as a delegate", func(t *testing.T) {
+		var res struct {
+			Token string `json:"token"`
+		}
+		json.Unmarshal(send("POST", "/session/delegates", hostToken, `{"scopes":["update","config","requests"]}`).Body.Bytes(), &res)
+		if w := send("POST", "/session/revoke", res.Token, `{"jti":"host"}`); w.Code != 403 {
+			t.Errorf("revokeToken as a delegate returned %v, expected %v", w.Code, 403)
+		}
+	})
+}
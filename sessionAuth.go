@@ -0,0 +1,176 @@
+package pogifyapi
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	gonanoid "github.com/matoous/go-nanoid"
+)
+
+// sessionPrincipalKey is the context key requireSession stores the
+// sessionPrincipal under
+const sessionPrincipalKey = "sessionPrincipal"
+
+// sessionPrincipal is the host or delegate a request was authenticated as
+type sessionPrincipal struct {
+	Session string
+	// Role is empty for the host
+	Role      string
+	TokenID   string
+	IssuedAt  int64
+	ExpiresAt int64
+}
+
+// newSessionToken signs a new host token for sessionID
+func (s *server) newSessionToken(sessionID string) (string, error) {
+	return s.newToken(sessionJwtClaims{Session: sessionID}, s.jwt.ttl)
+}
+
+// newToken fills in the registered claims and signs claims valid for ttl. A
+// token ID is generated unless claims already has one.
+func (s *server) newToken(claims sessionJwtClaims, ttl time.Duration) (string, error) {
+	if claims.Id == "" {
+		jti, err := gonanoid.ID(21)
+		if err != nil {
+			return "", err
+		}
+		claims.Id = jti
+	}
+
+	now := time.Now()
+	claims.Audience = s.tokenAudience(claims.Role)
+	claims.ExpiresAt = now.Add(ttl).Unix()
+	claims.IssuedAt = now.Unix()
+	claims.Issuer = s.jwt.issuer
+	claims.NotBefore = now.Unix()
+	return s.jwt.sign(claims)
+}
+
+// maxTokenTTL is the longest a host or listener token is valid for, and so how
+// long ending a session or revoking a token has to be remembered
+func (s *server) maxTokenTTL() time.Duration {
+	if s.jwt.ttl > listenerTokenTTL {
+		return s.jwt.ttl
+	}
+	return listenerTokenTTL
+}
+
+// tokenAudience returns the audience of tokens with role. Only host tokens
+// get JWT_AUDIENCE itself, so services verifying host tokens with the JWKS
+// don't take a listener or delegate token for one.
+func (s *server) tokenAudience(role string) string {
+	if role == "" {
+		return s.jwt.audience
+	}
+	return s.jwt.audience + ":" + role
+}
+
+// parseSessionToken parses and verifies a session token. Expired tokens still
+// return their claims along with the expiry error.
+func (s *server) parseSessionToken(tokenString string) (*sessionJwtClaims, error) {
+	claims := new(sessionJwtClaims)
+	_, err := s.jwt.parser.ParseWithClaims(tokenString, claims, s.jwt.keyFunc)
+	if ve, ok := err.(*jwt.ValidationError); err != nil && (!ok || ve.Errors&^jwt.ValidationErrorExpired != 0) {
+		return claims, err
+	}
+
+	// jwt-go only checks the time claims that are present and leaves issuer
+	// and audience to the caller
+	if claims.ExpiresAt == 0 || claims.IssuedAt == 0 {
+		return claims, jwt.NewValidationError("token is missing exp or iat", jwt.ValidationErrorClaimsInvalid)
+	}
+	if !claims.VerifyIssuer(s.jwt.issuer, true) {
+		return claims, jwt.NewValidationError("token has invalid issuer", jwt.ValidationErrorIssuer)
+	}
+	if !claims.VerifyAudience(s.tokenAudience(claims.Role), true) {
+		return claims, jwt.NewValidationError("token has invalid audience", jwt.ValidationErrorAudience)
+	}
+	if claims.Session == "" {
+		return claims, jwt.NewValidationError("token has no session", jwt.ValidationErrorClaimsInvalid)
+	}
+
+	return claims, err
+}
+
+// requireSession authenticates the host from the X-Session-Token header and
+// stores a sessionPrincipal in the context for the handlers after it
+func (s *server) requireSession(c *gin.Context) {
+	s.authenticate(c, "")
+}
+
+// requireScope is requireSession that also lets in delegates with scope
+func (s *server) requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.authenticate(c, scope)
+	}
+}
+
+// authenticate checks the X-Session-Token header for the host, or a delegate
+// with scope if scope isn't empty
+func (s *server) authenticate(c *gin.Context, scope string) {
+	sessionToken := c.GetHeader("X-Session-Token")
+	if sessionToken == "" {
+		c.String(400, "missing X-Session-Token header")
+		c.Abort()
+		return
+	}
+
+	claims, err := s.parseSessionToken(sessionToken)
+	if err != nil {
+		c.Error(err)
+		c.String(401, err.Error())
+		c.Abort()
+		return
+	}
+
+	switch {
+	case claims.Role == "":
+	case claims.Role == roleDelegate && hasScope(claims.Scopes, scope):
+		// delegates are revoked by removing them from the session
+		if exists, err := s.redis.delegateExists(claims.Session, claims.Id); err != nil {
+			c.AbortWithError(500, err)
+			return
+		} else if !exists {
+			c.String(401, "token revoked")
+			c.Abort()
+			return
+		}
+	case claims.Role == roleDelegate && scope != "":
+		c.String(403, "token is missing scope "+scope)
+		c.Abort()
+		return
+	default:
+		c.String(403, "not a host token")
+		c.Abort()
+		return
+	}
+
+	if revoked, err := s.tokenRevoked(claims); err != nil {
+		c.AbortWithError(500, err)
+		return
+	} else if revoked {
+		c.String(401, "token revoked")
+		c.Abort()
+		return
+	}
+
+	c.Set(sessionPrincipalKey, &sessionPrincipal{
+		Session:   claims.Session,
+		Role:      claims.Role,
+		TokenID:   claims.Id,
+		IssuedAt:  claims.IssuedAt,
+		ExpiresAt: claims.ExpiresAt,
+	})
+}
+
+// getPrincipal returns the sessionPrincipal set by requireSession
+func getPrincipal(c *gin.Context) (*sessionPrincipal, error) {
+	p, exists := c.Get(sessionPrincipalKey)
+	if !exists {
+		return nil, errors.New("sessionPrincipal not set in context")
+	}
+
+	return p.(*sessionPrincipal), nil
+}
@@ -0,0 +1,196 @@
+package pogifyapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+func Test_server_requireSession(t *testing.T) {
+	m, err := miniredis.Run()
+	defer m.Close()
+	if err != nil {
+		t.Fatalf("miniRedis errored: %v", err)
+	}
+
+	var r = new(r)
+	r.conn = redis.NewClient(&redis.Options{
+		Addr: m.Addr(),
+	})
+	r.refreshTokenTTL = "10"
+
+	s := &server{
+		redis: r,
+		jwt: &j{
+			secret:   []byte("secret"),
+			parser:   &jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Alg()}},
+			issuer:   "pogify",
+			audience: "pogify-api",
+		},
+	}
+
+	router := gin.New()
+	router.GET("/", s.requireSession, func(c *gin.Context) {
+		principal, err := getPrincipal(c)
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+		c.String(200, principal.Session)
+	})
+
+	valid := sessionJwtClaims{
+		Session: "test",
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Id:        "jti",
+			Issuer:    "pogify",
+			Audience:  "pogify-api",
+		},
+	}
+	sign := func(method jwt.SigningMethod, claims sessionJwtClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatalf("Error generating token: %v", err)
+		}
+		return token
+	}
+
+	expired := valid
+	expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	wrongIssuer := valid
+	wrongIssuer.Issuer = "someone"
+	wrongAudience := valid
+	wrongAudience.Audience = "someone"
+	noSession := valid
+	noSession.Session = ""
+	noIssuedAt := valid
+	noIssuedAt.IssuedAt = 0
+	notYet := valid
+	notYet.NotBefore = time.Now().Add(time.Minute).Unix()
+	// v1 tokens only carry the session and expiry
+	v1 := sessionJwtClaims{
+		Session: "test",
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	}
+	revoked := valid
+	revoked.Id = "revoked"
+	m.Set("revoked:revoked", "1")
+	delegated := valid
+	delegated.Role = roleDelegate
+	delegated.Scopes = []string{scopeUpdate, scopeConfig}
+	delegated.Audience = "pogify-api:" + roleDelegate
+	// non-host tokens can't pass for a host token with its audience
+	hostAudienceDelegate := delegated
+	hostAudienceDelegate.Audience = "pogify-api"
+	listener := valid
+	listener.Role = roleAnonymous
+	listener.Audience = "pogify-api:" + roleAnonymous
+	hostAudienceListener := listener
+	hostAudienceListener.Audience = "pogify-api"
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, valid).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"no token", "", 400},
+		{"valid token", sign(jwt.SigningMethodHS256, valid), 200},
+		{"other algorithm", sign(jwt.SigningMethodHS512, valid), 401},
+		{"unsigned", none, 401},
+		{"expired", sign(jwt.SigningMethodHS256, expired), 401},
+		{"wrong issuer", sign(jwt.SigningMethodHS256, wrongIssuer), 401},
+		{"wrong audience", sign(jwt.SigningMethodHS256, wrongAudience), 401},
+		{"no session", sign(jwt.SigningMethodHS256, noSession), 401},
+		{"no iat", sign(jwt.SigningMethodHS256, noIssuedAt), 401},
+		{"not yet valid", sign(jwt.SigningMethodHS256, notYet), 401},
+		{"v1 token", sign(jwt.SigningMethodHS256, v1), 401},
+		{"revoked", sign(jwt.SigningMethodHS256, revoked), 401},
+		{"delegate", sign(jwt.SigningMethodHS256, delegated), 403},
+		{"delegate with the host audience", sign(jwt.SigningMethodHS256, hostAudienceDelegate), 401},
+		{"listener", sign(jwt.SigningMethodHS256, listener), 403},
+		{"listener with the host audience", sign(jwt.SigningMethodHS256, hostAudienceListener), 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/", nil)
+			if tt.token != "" {
+				req.Header.Add("X-Session-Token", tt.token)
+			}
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("requireSession returned %v, expected %v: %v", w.Code, tt.want, w.Body.String())
+			}
+			if w.Code == 200 && w.Body.String() != "test" {
+				t.Errorf("requireSession set principal for %q, expected %q", w.Body.String(), "test")
+			}
+		})
+	}
+}
+
+func Test_server_newSessionToken(t *testing.T) {
+	newServer := func(audience string) *server {
+		return &server{
+			jwt: &j{
+				secret:   []byte("secret"),
+				parser:   &jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Alg()}},
+				issuer:   defaultJWTIssuer,
+				audience: audience,
+				ttl:      time.Minute,
+			},
+		}
+	}
+	s := newServer(defaultJWTAudience)
+
+	token, err := s.newSessionToken("test")
+	if err != nil {
+		t.Fatalf("newSessionToken errored with: %v", err)
+	}
+
+	claims, err := s.parseSessionToken(token)
+	if err != nil {
+		t.Fatalf("parseSessionToken errored with: %v", err)
+	}
+	if claims.Session != "test" || claims.Id == "" {
+		t.Errorf("newSessionToken issued claims %+v", claims)
+	}
+	if claims.ExpiresAt-claims.IssuedAt != 60 {
+		t.Errorf("newSessionToken issued token valid for %vs, expected %vs", claims.ExpiresAt-claims.IssuedAt, 60)
+	}
+
+	// the same secret with a different audience, like another API version
+	if _, err := newServer("pogify-api/v1").parseSessionToken(token); err == nil {
+		t.Errorf("parseSessionToken accepted a token for another audience")
+	}
+}
+
+func Test_server_maxTokenTTL(t *testing.T) {
+	tests := []struct {
+		name       string
+		sessionTTL time.Duration
+		want       time.Duration
+	}{
+		{"session tokens live longer", 2 * time.Hour, 2 * time.Hour},
+		{"listener tokens live longer", time.Minute, listenerTokenTTL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{jwt: &j{ttl: tt.sessionTTL}}
+			if got := s.maxTokenTTL(); got != tt.want {
+				t.Errorf("server.maxTokenTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,42 +3,105 @@ package pogifyapi
 import (
 	"fmt"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type config struct {
 	RequestInterval int `json:"requestInterval" binding:"required"`
+	// Password is set in plain text by the host and stored as a bcrypt hash.
+	// Sessions with a password are private.
+	Password string `json:"password,omitempty" binding:"max=72"`
+	// DuplicateWindow is how many seconds a requested song counts as a
+	// duplicate for. 0 allows duplicates.
+	DuplicateWindow int `json:"duplicateWindow" binding:"min=0,max=86400"`
+	// DuplicatePolicy is duplicateMerge or duplicateReject and defaults to
+	// merging
+	DuplicatePolicy string `json:"duplicatePolicy" binding:"omitempty,oneof=merge reject"`
 }
 
-func (s *server) setConfig(c *gin.Context) {
-	sessionToken := c.GetHeader("X-Session-Token")
-	if sessionToken == "" {
-		c.String(400, "missing X-Session-Token header")
-		return
+// configUpdate changes the fields of a session's config that are present, so
+// leaving out the password keeps a private session private. An empty password
+// makes the session public again.
+type configUpdate struct {
+	RequestInterval *int    `json:"requestInterval" binding:"required,min=1"`
+	Password        *string `json:"password" binding:"omitempty,max=72"`
+	DuplicateWindow *int    `json:"duplicateWindow" binding:"omitempty,min=0,max=86400"`
+	DuplicatePolicy *string `json:"duplicatePolicy" binding:"omitempty,oneof=merge reject"`
+}
+
+// fields returns the fields to store by their name in config
+func (u *configUpdate) fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"RequestInterval": *u.RequestInterval,
+	}
+	if u.Password != nil {
+		fields["Password"] = *u.Password
+	}
+	if u.DuplicateWindow != nil {
+		fields["DuplicateWindow"] = *u.DuplicateWindow
+	}
+	if u.DuplicatePolicy != nil {
+		fields["DuplicatePolicy"] = *u.DuplicatePolicy
+	}
+	return fields
+}
+
+// duplicate policies
+const (
+	// duplicateMerge counts a duplicate towards the original request
+	duplicateMerge = "merge"
+	// duplicateReject turns a duplicate away with the original requester
+	duplicateReject = "reject"
+)
+
+// public returns the config listeners can see
+func (conf *config) public() gin.H {
+	policy := conf.DuplicatePolicy
+	if policy == "" {
+		policy = duplicateMerge
 	}
 
-	token, err := jwt.Parse(sessionToken, func(t *jwt.Token) (interface{}, error) {
-		return s.jwt.secret, nil
-	})
+	return gin.H{
+		"requestInterval": conf.RequestInterval,
+		"private":         conf.Password != "",
+		"duplicateWindow": conf.DuplicateWindow,
+		"duplicatePolicy": policy,
+	}
+}
 
+func (s *server) setConfig(c *gin.Context) {
+	principal, err := getPrincipal(c)
 	if err != nil {
-		c.Error(err)
-		c.String(401, fmt.Sprint(err))
+		c.AbortWithError(500, err)
 		return
 	}
 
-	sessionID := token.Claims.(jwt.MapClaims)["session"].(string)
+	sessionID := principal.Session
 
-	var conf config
-	err = c.ShouldBindJSON(&conf)
+	var update configUpdate
+	err = c.ShouldBindJSON(&update)
 	if err != nil {
 		c.Error(err)
 		c.String(400, fmt.Sprint(err))
 		return
 	}
 
-	err = s.redis.setSessionConfig(sessionID, conf)
+	if update.Password != nil && *update.Password != "" {
+		if !s.privateSessions {
+			c.String(400, "private sessions aren't supported by this pubsub driver")
+			return
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(*update.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+		*update.Password = string(hash)
+	}
+
+	err = s.redis.setSessionConfig(sessionID, update.fields())
 
 	if err != nil {
 		c.AbortWithError(500, err)
@@ -16,9 +16,12 @@ import (
 func Test_server_setConfig(t *testing.T) {
 	sessionCode := "test"
 	claims := sessionJwtClaims{
-		sessionCode,
-		jwt.StandardClaims{
+		Session: sessionCode,
+		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Issuer:    defaultJWTIssuer,
+			Audience:  defaultJWTAudience,
 		},
 	}
 
@@ -96,4 +99,41 @@ func Test_server_setConfig(t *testing.T) {
 
 	})
 
+	t.Run("partial update", func(t *testing.T) {
+		mr.HSet("session:test:config", "DuplicateWindow", "60")
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/config", bytes.NewReader([]byte("{\"requestInterval\":50}")))
+		req.Header.Add("X-Session-Token", mockToken)
+		router.ServeHTTP(w, req)
+
+		if w.Code != 200 || mr.HGet("session:test:config", "RequestInterval") != "50" {
+			t.Fatalf("setConfig returned %v, expected %v", w.Code, 200)
+		}
+		if mr.HGet("session:test:config", "DuplicateWindow") != "60" {
+			t.Error("setConfig overwrote a field that was left out")
+		}
+	})
+
+	t.Run("password with nchan", func(t *testing.T) {
+		os.Setenv("PUBSUB_DRIVER", "nchan")
+		os.Setenv("PUBSUB_URL", "http://localhost")
+		defer os.Setenv("PUBSUB_DRIVER", "fake")
+		defer os.Unsetenv("PUBSUB_URL")
+
+		router := gin.New()
+		Server(router.Group("/"))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/config", bytes.NewReader([]byte("{\"requestInterval\":50,\"password\":\"hunter2\"}")))
+		req.Header.Add("X-Session-Token", mockToken)
+		router.ServeHTTP(w, req)
+
+		if w.Code != 400 {
+			t.Errorf("setConfig with a password under nchan returned %v, expected %v", w.Code, 400)
+		}
+		if mr.HGet("session:test:config", "Password") != "" {
+			t.Error("setConfig stored a password under nchan")
+		}
+	})
 }
@@ -0,0 +1,117 @@
+package pogifyapi
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// songRequest is a listener's request in canonical form
+type songRequest struct {
+	Provider string `json:"provider"`
+	Kind     string `json:"kind"`
+	ID       string `json:"id"`
+}
+
+var errUnsupportedRequest = errors.New("unsupported request, expected a Spotify track, album or playlist or a YouTube video or playlist")
+
+var (
+	spotifyID         = regexp.MustCompile(`^[0-9A-Za-z]{22}$`)
+	youtubeVideoID    = regexp.MustCompile(`^[0-9A-Za-z_-]{11}$`)
+	youtubePlaylistID = regexp.MustCompile(`^[0-9A-Za-z_-]{12,64}$`)
+)
+
+// spotifyKinds are the Spotify items that can be requested
+var spotifyKinds = map[string]bool{
+	"track":    true,
+	"album":    true,
+	"playlist": true,
+}
+
+// parseSongRequest parses a Spotify URI or a Spotify or YouTube link
+func parseSongRequest(raw string) (*songRequest, error) {
+	raw = strings.TrimSpace(raw)
+
+	if strings.HasPrefix(raw, "spotify:") {
+		// spotify:<kind>:<id> or the older spotify:user:<user>:playlist:<id>
+		parts := strings.Split(raw, ":")
+		if len(parts) == 5 && parts[1] == "user" {
+			parts = parts[2:]
+		}
+		if len(parts) != 3 {
+			return nil, errUnsupportedRequest
+		}
+		return newSpotifyRequest(parts[1], parts[2])
+	}
+
+	// links are often pasted without the scheme
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, errUnsupportedRequest
+	}
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	switch strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.") {
+	case "open.spotify.com", "play.spotify.com":
+		// /intl-<lang>/, /embed/ and /user/<user>/ come before the kind
+		if len(path) > 0 && strings.HasPrefix(path[0], "intl-") {
+			path = path[1:]
+		}
+		if len(path) > 0 && path[0] == "embed" {
+			path = path[1:]
+		}
+		if len(path) == 4 && path[0] == "user" {
+			path = path[2:]
+		}
+		if len(path) != 2 {
+			return nil, errUnsupportedRequest
+		}
+		return newSpotifyRequest(path[0], path[1])
+
+	case "youtu.be":
+		if len(path) != 1 {
+			return nil, errUnsupportedRequest
+		}
+		return newYouTubeRequest("video", path[0])
+
+	case "youtube.com", "m.youtube.com", "music.youtube.com", "youtube-nocookie.com":
+		switch {
+		case len(path) == 1 && path[0] == "watch":
+			return newYouTubeRequest("video", u.Query().Get("v"))
+		case len(path) == 1 && path[0] == "playlist":
+			return newYouTubeRequest("playlist", u.Query().Get("list"))
+		case len(path) == 2 && (path[0] == "embed" || path[0] == "v" || path[0] == "shorts" || path[0] == "live"):
+			return newYouTubeRequest("video", path[1])
+		}
+	}
+
+	return nil, errUnsupportedRequest
+}
+
+func newSpotifyRequest(kind string, id string) (*songRequest, error) {
+	if !spotifyKinds[kind] {
+		return nil, fmt.Errorf("unsupported Spotify %v, expected a track, album or playlist", kind)
+	}
+	if !spotifyID.MatchString(id) {
+		return nil, fmt.Errorf("invalid Spotify %v id %q", kind, id)
+	}
+
+	return &songRequest{"spotify", kind, id}, nil
+}
+
+func newYouTubeRequest(kind string, id string) (*songRequest, error) {
+	valid := youtubeVideoID
+	if kind == "playlist" {
+		valid = youtubePlaylistID
+	}
+	if !valid.MatchString(id) {
+		return nil, fmt.Errorf("invalid YouTube %v id %q", kind, id)
+	}
+
+	return &songRequest{"youtube", kind, id}, nil
+}
@@ -0,0 +1,61 @@
+package pogifyapi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseSongRequest(t *testing.T) {
+	track := &songRequest{"spotify", "track", "4uLU6hMCjMI75M1A2tKUQC"}
+	video := &songRequest{"youtube", "video", "dQw4w9WgXcQ"}
+
+	tests := []struct {
+		raw     string
+		want    *songRequest
+		wantErr bool
+	}{
+		{"spotify:track:4uLU6hMCjMI75M1A2tKUQC", track, false},
+		{"spotify:album:1DFixLWuPkv3KT3TnV35m3", &songRequest{"spotify", "album", "1DFixLWuPkv3KT3TnV35m3"}, false},
+		{"spotify:user:someone:playlist:37i9dQZF1DXcBWIGoYBM5M", &songRequest{"spotify", "playlist", "37i9dQZF1DXcBWIGoYBM5M"}, false},
+		{"https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC?si=abc", track, false},
+		{" open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC ", track, false},
+		{"https://open.spotify.com/intl-de/track/4uLU6hMCjMI75M1A2tKUQC", track, false},
+		{"https://open.spotify.com/embed/track/4uLU6hMCjMI75M1A2tKUQC", track, false},
+		{"https://open.spotify.com/user/someone/playlist/37i9dQZF1DXcBWIGoYBM5M", &songRequest{"spotify", "playlist", "37i9dQZF1DXcBWIGoYBM5M"}, false},
+		{"https://play.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC", track, false},
+		{"spotify:artist:0gxyHStUsqpMadRV0Di1Qt", nil, true},
+		{"spotify:track:short", nil, true},
+		{"https://open.spotify.com/episode/4uLU6hMCjMI75M1A2tKUQC", nil, true},
+		{"https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC/extra", nil, true},
+
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=42", video, false},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ", video, false},
+		{"https://music.youtube.com/watch?v=dQw4w9WgXcQ&list=RDAMVMdQw4w9WgXcQ", video, false},
+		{"youtu.be/dQw4w9WgXcQ", video, false},
+		{"https://youtu.be/dQw4w9WgXcQ?t=1", video, false},
+		{"https://www.youtube.com/embed/dQw4w9WgXcQ", video, false},
+		{"https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ", video, false},
+		{"https://youtube.com/shorts/dQw4w9WgXcQ", video, false},
+		{"https://www.youtube.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI", &songRequest{"youtube", "playlist", "PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI"}, false},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXc", nil, true},
+		{"https://www.youtube.com/watch", nil, true},
+		{"https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw", nil, true},
+
+		{"not a request", nil, true},
+		{"", nil, true},
+		{"ftp://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC", nil, true},
+		{"https://example.com/track/4uLU6hMCjMI75M1A2tKUQC", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parseSongRequest(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseSongRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSongRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
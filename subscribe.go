@@ -0,0 +1,158 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+// browsers can't set headers on websockets and query strings end up in
+// access logs, so tokens are offered in Sec-WebSocket-Protocol after one of
+// these protocols
+const (
+	wsSessionTokenProtocol  = "pogify.session-token"
+	wsListenerTokenProtocol = "pogify.listener-token"
+)
+
+var upgrader = websocket.Upgrader{
+	// listeners connect from any origin, same as s.cors allows
+	CheckOrigin:  func(r *http.Request) bool { return true },
+	Subprotocols: []string{wsSessionTokenProtocol, wsListenerTokenProtocol},
+}
+
+// wsToken returns the token offered after protocol in Sec-WebSocket-Protocol
+func wsToken(r *http.Request, protocol string) string {
+	protocols := websocket.Subprotocols(r)
+	for i := 0; i+1 < len(protocols); i++ {
+		if protocols[i] == protocol {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+func (s *server) subscribe(c *gin.Context) {
+	sessionID := c.Query("session")
+	if sessionID == "" {
+		c.String(400, "no session query")
+		return
+	}
+
+	subscriber, ok := s.pubsub.(Subscriber)
+	if !ok {
+		c.String(501, "pubsub driver doesn't support subscriptions")
+		return
+	}
+
+	if c.Query("token") != "" {
+		c.String(400, "pass the session token in Sec-WebSocket-Protocol")
+		return
+	}
+
+	channel := sessionID
+	// hosts pass their session token to receive listener requests instead
+	if sessionToken := wsToken(c.Request, wsSessionTokenProtocol); sessionToken != "" {
+		claims, err := s.parseSessionToken(sessionToken)
+		if err != nil {
+			c.String(401, err.Error())
+			return
+		}
+		if claims.Session != sessionID || claims.Role != "" {
+			c.String(403, "token is for a different session")
+			return
+		}
+		if revoked, err := s.tokenRevoked(claims); err != nil {
+			c.AbortWithError(500, err)
+			return
+		} else if revoked {
+			c.String(401, "token revoked")
+			return
+		}
+		channel = "host_" + sessionID
+	} else if !s.authorizeListener(c, sessionID, wsToken(c.Request, wsListenerTokenProtocol)) {
+		return
+	}
+
+	sub, err := subscriber.Subscribe(channel)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	defer sub.Close()
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade already replied with an error
+		c.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	// catch listeners up with the latest state
+	if channel == sessionID {
+		state, err := s.redis.getState(sessionID)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if state != nil {
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.TextMessage, state.Data); err != nil {
+				return
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		// listeners don't send anything; read only to notice pongs and closes
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(wsWriteWait))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+			// nothing follows the end of the session
+			var e envelope
+			if json.Unmarshal(msg, &e) == nil && e.Type == sessionEndedEvent {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "session ended"), time.Now().Add(wsWriteWait))
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
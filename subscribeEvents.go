@@ -0,0 +1,177 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+const sseKeepAlive = 30 * time.Second
+
+// subscribeEvents streams session updates as Server-Sent Events for listeners
+// that can't use WebSockets
+func (s *server) subscribeEvents(c *gin.Context) {
+	sessionID := c.Query("session")
+	if sessionID == "" {
+		c.String(400, "no session query")
+		return
+	}
+
+	// EventSource can't set headers
+	if !s.authorizeListener(c, sessionID, c.Query("listenerToken")) {
+		return
+	}
+
+	subscriber, ok := s.pubsub.(Subscriber)
+	if !ok {
+		c.String(501, "pubsub driver doesn't support subscriptions")
+		return
+	}
+
+	var lastSeq int64
+	resume := c.GetHeader("Last-Event-ID")
+	if resume != "" {
+		var err error
+		lastSeq, err = strconv.ParseInt(resume, 10, 64)
+		if err != nil {
+			c.String(400, "invalid Last-Event-ID")
+			return
+		}
+	}
+
+	sub, err := subscriber.Subscribe(sessionID)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(200)
+	c.Writer.WriteHeaderNow()
+
+	// sendSince writes every stored update after lastSeq. When the history
+	// no longer goes back that far, the state keyframe is sent first so the
+	// patches after it have their base.
+	sendSince := func() bool {
+		updates, err := s.redis.updatesSince(sessionID, lastSeq)
+		if err != nil {
+			c.Error(err)
+			return false
+		}
+		if len(updates) == 0 || updates[0].Seq > lastSeq+1 {
+			state, err := s.redis.getState(sessionID)
+			if err != nil {
+				c.Error(err)
+				return false
+			}
+			if state != nil && state.Seq > lastSeq {
+				c.Render(-1, sse.Event{
+					Id:   strconv.FormatInt(state.Seq, 10),
+					Data: string(state.Data),
+				})
+				lastSeq = state.Seq
+			}
+		}
+		for _, u := range updates {
+			if u.Seq <= lastSeq {
+				continue
+			}
+			c.Render(-1, sse.Event{
+				Id:   strconv.FormatInt(u.Seq, 10),
+				Data: string(u.Data),
+			})
+			lastSeq = u.Seq
+		}
+		return true
+	}
+
+	// restarted returns whether the session's seq is behind lastSeq, which
+	// happens when its code was claimed again by a new session that starts
+	// over from 1
+	restarted := func() (bool, error) {
+		seq, err := s.redis.updateSeq(sessionID)
+		return seq < lastSeq, err
+	}
+
+	if resume != "" {
+		if reset, err := restarted(); err != nil {
+			c.Error(err)
+			return
+		} else if reset {
+			lastSeq = 0
+		}
+		if !sendSince() {
+			return
+		}
+	} else {
+		// catch new listeners up with the latest state
+		state, err := s.redis.getState(sessionID)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if state != nil {
+			c.Render(-1, sse.Event{
+				Id:   strconv.FormatInt(state.Seq, 10),
+				Data: string(state.Data),
+			})
+			lastSeq = state.Seq
+		}
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return false
+			}
+			var e envelope
+			if err := json.Unmarshal(msg, &e); err != nil {
+				c.Error(err)
+				return true
+			}
+			// unsequenced events can't be resumed, so they're sent as they come
+			if e.Seq == 0 {
+				c.Render(-1, sse.Event{Data: string(msg)})
+				return true
+			}
+			if e.Seq <= lastSeq {
+				if reset, err := restarted(); err != nil {
+					c.Error(err)
+					return false
+				} else if reset {
+					lastSeq = 0
+				}
+			}
+			// fill in anything dropped since the last event from the history
+			if e.Seq > lastSeq+1 && !sendSince() {
+				return false
+			}
+			if e.Seq > lastSeq {
+				c.Render(-1, sse.Event{
+					Id:   strconv.FormatInt(e.Seq, 10),
+					Data: string(msg),
+				})
+				lastSeq = e.Seq
+			}
+			// nothing follows the end of the session
+			return e.Type != sessionEndedEvent
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ":\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
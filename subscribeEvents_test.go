@@ -0,0 +1,271 @@
+package pogifyapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_server_subscribeEvents(t *testing.T) {
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+	os.Setenv("PUBSUB_DRIVER", "local")
+	defer os.Setenv("PUBSUB_DRIVER", "fake")
+
+	router := gin.New()
+	Server(router.Group("/"))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	sessionToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, sessionJwtClaims{
+		Session: "test",
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Issuer:    defaultJWTIssuer,
+			Audience:  defaultJWTAudience,
+		},
+	}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+
+	postUpdate := func(body string) string {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/update", strings.NewReader(body))
+		req.Header.Add("X-Session-Token", sessionToken)
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	// readEvent returns the id and data lines of the next event on r
+	readEvent := func(t *testing.T, r *bufio.Reader) (id string, data string) {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read errored with: %v", err)
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "" && data != "":
+				return
+			case strings.HasPrefix(line, "id:"):
+				id = strings.TrimSpace(strings.TrimPrefix(line, "id:"))
+			case strings.HasPrefix(line, "data:"):
+				data = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			}
+		}
+	}
+
+	subscribe := func(t *testing.T, lastEventID string) (*bufio.Reader, func()) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/session/events?session=test", nil)
+		if lastEventID != "" {
+			req.Header.Add("Last-Event-ID", lastEventID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			cancel()
+			t.Fatalf("subscribe errored with: %v", err)
+		}
+		if res.StatusCode != 200 {
+			cancel()
+			t.Fatalf("subscribe returned %v", res.StatusCode)
+		}
+		if got := res.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+			t.Errorf("subscribe didn't set cors headers, got: %v", got)
+		}
+		return bufio.NewReader(res.Body), func() {
+			cancel()
+			res.Body.Close()
+		}
+	}
+
+	t.Run("missing session", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/session/events", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != 400 {
+			t.Errorf("subscribeEvents returned %v, expected %v", w.Code, 400)
+		}
+	})
+
+	t.Run("invalid Last-Event-ID", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/session/events?session=test", nil)
+		req.Header.Add("Last-Event-ID", "abc")
+		router.ServeHTTP(w, req)
+
+		if w.Code != 400 {
+			t.Errorf("subscribeEvents returned %v, expected %v", w.Code, 400)
+		}
+	})
+
+	t.Run("replays state then streams updates", func(t *testing.T) {
+		postUpdate(testUpdate("spotify:track:old"))
+
+		r, done := subscribe(t, "")
+		defer done()
+
+		for i := 0; i < 100 && !strings.Contains(postUpdate(testUpdate("spotify:track:new")), "\"subscribers\":1"); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		id, data := readEvent(t, r)
+		if id != "1" || string(unwrap(t, []byte(data)).Payload) != testUpdate("spotify:track:old") {
+			t.Errorf("got %v: %v, expected the state before subscribing", id, data)
+		}
+
+		id, data = readEvent(t, r)
+		if string(unwrap(t, []byte(data)).Payload) != "{\"uri\":\"spotify:track:new\"}" {
+			t.Errorf("got data %v, expected the update posted after subscribing", data)
+		}
+		if id == "" || id == "1" {
+			t.Errorf("got unexpected id %v", id)
+		}
+	})
+
+	t.Run("resumes from Last-Event-ID", func(t *testing.T) {
+		mr.FlushAll()
+		postUpdate(testUpdate("spotify:track:1"))
+		postUpdate(testUpdate("spotify:track:2"))
+		postUpdate(testUpdate("spotify:track:3"))
+
+		r, done := subscribe(t, "1")
+		defer done()
+
+		for _, expect := range []string{"2", "3"} {
+			id, data := readEvent(t, r)
+			if id != expect {
+				t.Errorf("got id %v, expected %v", id, expect)
+			}
+			if string(unwrap(t, []byte(data)).Payload) != "{\"uri\":\"spotify:track:"+expect+"\"}" {
+				t.Errorf("got data %v for id %v", data, id)
+			}
+		}
+	})
+
+	t.Run("resumes from the state past the history", func(t *testing.T) {
+		mr.FlushAll()
+		for _, uri := range []string{"1", "2", "3", "4"} {
+			postUpdate(testUpdate("spotify:track:" + uri))
+		}
+		// the history was trimmed past the resumed update
+		members, _ := mr.ZMembers("session:test:updates")
+		for _, m := range members {
+			if !strings.HasPrefix(m, "4:") {
+				mr.ZRem("session:test:updates", m)
+			}
+		}
+
+		r, done := subscribe(t, "1")
+		defer done()
+
+		id, data := readEvent(t, r)
+		e := unwrap(t, []byte(data))
+		if id != "4" || e.Type != updateKeyframe || string(e.Payload) != testUpdate("spotify:track:4") {
+			t.Errorf("got %v: %v, expected the state keyframe", id, data)
+		}
+	})
+
+	t.Run("streams unsequenced events", func(t *testing.T) {
+		send := func(endpoint string, body string) string {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", endpoint, strings.NewReader(body))
+			router.ServeHTTP(w, req)
+			return w.Body.String()
+		}
+
+		r, done := subscribe(t, "3")
+		defer done()
+
+		for i := 0; i < 100 && !strings.Contains(postUpdate(testUpdate("spotify:track:4")), "\"subscribers\":1"); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		var l struct {
+			Token string `json:"token"`
+		}
+		mr.Set("session:test", "refresh")
+		json.Unmarshal([]byte(send("/session/listener", `{"session":"test"}`)), &l)
+		var q queuedRequest
+		json.Unmarshal([]byte(send("/session/request", `{"session":"test","provider":"listener","token":"`+l.Token+`","request":"spotify:track:4uLU6hMCjMI75M1A2tKUQC"}`)), &q)
+		send("/session/requests/vote", `{"session":"test","provider":"listener","token":"`+l.Token+`","request":"`+q.ID+`","vote":1}`)
+
+		// skip the updates posted while subscribing
+		id, data := readEvent(t, r)
+		for id != "" {
+			id, data = readEvent(t, r)
+		}
+		var e envelope
+		json.Unmarshal([]byte(data), &e)
+		if e.Type != requestVotesEvent {
+			t.Errorf("got %v, expected a %v event", data, requestVotesEvent)
+		}
+	})
+	t.Run("restarts when the code is reused", func(t *testing.T) {
+		mr.FlushAll()
+		postUpdate(testUpdate("spotify:track:1"))
+		postUpdate(testUpdate("spotify:track:2"))
+
+		// resuming from a previous session on the same code
+		r, done := subscribe(t, "50")
+		defer done()
+
+		for _, expect := range []string{"1", "2"} {
+			if id, _ := readEvent(t, r); id != expect {
+				t.Errorf("got id %v, expected %v", id, expect)
+			}
+		}
+
+		// the session is replaced while streaming
+		for _, key := range []string{"session:test:seq", "session:test:state", "session:test:updates"} {
+			mr.Del(key)
+		}
+		postUpdate(testUpdate("spotify:track:new"))
+
+		id, data := readEvent(t, r)
+		if id != "1" || string(unwrap(t, []byte(data)).Payload) != testUpdate("spotify:track:new") {
+			t.Errorf("got %v: %v, expected the new session's first update", id, data)
+		}
+	})
+
+	t.Run("closes when the session ends", func(t *testing.T) {
+		r, done := subscribe(t, "")
+		defer done()
+
+		for i := 0; i < 100 && !strings.Contains(postUpdate(testUpdate("spotify:track:5")), "\"subscribers\":1"); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/end", nil)
+		req.Header.Add("X-Session-Token", sessionToken)
+		router.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("endSession returned %v, expected %v", w.Code, 200)
+		}
+
+		// skip the updates posted while subscribing
+		for {
+			if _, data := readEvent(t, r); unwrap(t, []byte(data)).Type == sessionEndedEvent {
+				break
+			}
+		}
+		if _, err := r.ReadString('\n'); err != io.EOF {
+			t.Errorf("read after the session ended returned %v, expected %v", err, io.EOF)
+		}
+	})
+}
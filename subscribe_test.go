@@ -0,0 +1,219 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func Test_server_subscribe(t *testing.T) {
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+
+	t.Run("unsupported driver", func(t *testing.T) {
+		router := gin.New()
+		Server(router.Group("/"))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/session/subscribe?session=test", nil)
+		router.ServeHTTP(w, req)
+
+		if expect := http.StatusNotImplemented; w.Code != expect {
+			t.Errorf("subscribe returned %v, expected %v", w.Code, expect)
+		}
+	})
+
+	os.Setenv("PUBSUB_DRIVER", "local")
+	defer os.Setenv("PUBSUB_DRIVER", "fake")
+
+	router := gin.New()
+	Server(router.Group("/"))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/session/subscribe?session="
+
+	t.Run("missing session", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/session/subscribe", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != 400 {
+			t.Errorf("subscribe returned %v, expected %v", w.Code, 400)
+		}
+	})
+
+	// dialToken offers token in Sec-WebSocket-Protocol after protocol
+	dialToken := func(url string, protocol string, token string) (*websocket.Conn, *http.Response, error) {
+		dialer := websocket.Dialer{Subprotocols: []string{protocol, token}}
+		return dialer.Dial(url, nil)
+	}
+
+	t.Run("token in query", func(t *testing.T) {
+		_, res, err := websocket.DefaultDialer.Dial(wsURL+"test&token=not.a.token", nil)
+		if err == nil {
+			t.Fatal("dial with a token in the query succeeded")
+		}
+		if res.StatusCode != 400 {
+			t.Errorf("subscribe returned %v, expected %v", res.StatusCode, 400)
+		}
+	})
+
+	t.Run("invalid host token", func(t *testing.T) {
+		_, res, err := dialToken(wsURL+"test", wsSessionTokenProtocol, "not.a.token")
+		if err == nil {
+			t.Fatal("dial with invalid token succeeded")
+		}
+		if res.StatusCode != 401 {
+			t.Errorf("subscribe returned %v, expected %v", res.StatusCode, 401)
+		}
+	})
+
+	sessionToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, sessionJwtClaims{
+		Session: "test",
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Issuer:    defaultJWTIssuer,
+			Audience:  defaultJWTAudience,
+		},
+	}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+
+	// postUntilDelivered posts to endpoint until the response reports a
+	// delivery, since the socket handler subscribes after the dial returns
+	postUntilDelivered := func(t *testing.T, endpoint string, body string, header http.Header, delivered func(*httptest.ResponseRecorder) bool) {
+		for i := 0; i < 100; i++ {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", endpoint, strings.NewReader(body))
+			req.Header = header
+			router.ServeHTTP(w, req)
+			if delivered(w) {
+				return
+			}
+			mr.FlushAll()
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%v never delivered", endpoint)
+	}
+
+	t.Run("listener receives updates", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+"test", nil)
+		if err != nil {
+			t.Fatalf("dial errored with: %v", err)
+		}
+		defer conn.Close()
+
+		update := testUpdate("spotify:track:a")
+		header := http.Header{"X-Session-Token": []string{sessionToken}}
+		postUntilDelivered(t, "/session/update", update, header, func(w *httptest.ResponseRecorder) bool {
+			return w.Code == 200 && strings.Contains(w.Body.String(), "\"subscribers\":1")
+		})
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read errored with: %v", err)
+		}
+		if got := string(unwrap(t, msg).Payload); got != update {
+			t.Errorf("listener got %v, expected %v", got, update)
+		}
+	})
+
+	t.Run("listener receives state on connect", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+"test", nil)
+		if err != nil {
+			t.Fatalf("dial errored with: %v", err)
+		}
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read errored with: %v", err)
+		}
+		if expect, got := testUpdate("spotify:track:a"), string(unwrap(t, msg).Payload); got != expect {
+			t.Errorf("listener got %v, expected %v", got, expect)
+		}
+	})
+
+	t.Run("host receives requests", func(t *testing.T) {
+		listener, _, err := websocket.DefaultDialer.Dial(wsURL+"test", nil)
+		if err != nil {
+			t.Fatalf("dial errored with: %v", err)
+		}
+		defer listener.Close()
+
+		host, _, err := dialToken(wsURL+"test", wsSessionTokenProtocol, sessionToken)
+		if err != nil {
+			t.Fatalf("dial errored with: %v", err)
+		}
+		defer host.Close()
+		if host.Subprotocol() != wsSessionTokenProtocol {
+			t.Errorf("subscribe selected protocol %q, expected %q", host.Subprotocol(), wsSessionTokenProtocol)
+		}
+
+		request := "{\"session\":\"test\",\"provider\":\"test\",\"token\":\"not.a.token\",\"request\":\"https://youtu.be/dQw4w9WgXcQ\"}"
+		postUntilDelivered(t, "/session/request", request, http.Header{}, func(w *httptest.ResponseRecorder) bool {
+			return w.Code == 200
+		})
+
+		host.SetReadDeadline(time.Now().Add(time.Second))
+		_, msg, err := host.ReadMessage()
+		if err != nil {
+			t.Fatalf("read errored with: %v", err)
+		}
+		var queued queuedRequest
+		json.Unmarshal(msg, &queued)
+		if expect := (songRequest{"youtube", "video", "dQw4w9WgXcQ"}); queued.Song != expect || queued.ID == "" || queued.Status != requestPending {
+			t.Errorf("host got %v, expected a pending request for %v", string(msg), expect)
+		}
+	})
+	t.Run("listener is closed when the session ends", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+"test", nil)
+		if err != nil {
+			t.Fatalf("dial errored with: %v", err)
+		}
+		defer conn.Close()
+
+		header := http.Header{"X-Session-Token": []string{sessionToken}}
+		postUntilDelivered(t, "/session/update", testUpdate("spotify:track:b"), header, func(w *httptest.ResponseRecorder) bool {
+			return w.Code == 200 && strings.Contains(w.Body.String(), "\"subscribers\":1")
+		})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/end", nil)
+		req.Header = header
+		router.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("endSession returned %v, expected %v", w.Code, 200)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var last envelope
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					t.Errorf("read errored with: %v, expected a normal close", err)
+				}
+				break
+			}
+			json.Unmarshal(msg, &last)
+		}
+		if last.Type != sessionEndedEvent {
+			t.Errorf("last message was %+v, expected the %v event", last, sessionEndedEvent)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package pogifyapi
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// vanityTTL is how long a vanity code stays reserved after it was last
+// reserved or claimed, so codes of deleted or abandoned accounts are released
+const vanityTTL = 90 * 24 * time.Hour
+
+// vanityCodeFormat matches the codes hosts can reserve, like channel names
+var vanityCodeFormat = regexp.MustCompile(`^[a-z0-9_]{3,25}$`)
+
+// reservedVanityCodes can't be reserved by anyone
+var reservedVanityCodes = map[string]bool{
+	"admin":     true,
+	"api":       true,
+	"auth":      true,
+	"claim":     true,
+	"google":    true,
+	"help":      true,
+	"login":     true,
+	"logout":    true,
+	"mod":       true,
+	"moderator": true,
+	"null":      true,
+	"official":  true,
+	"pogify":    true,
+	"root":      true,
+	"session":   true,
+	"spotify":   true,
+	"staff":     true,
+	"support":   true,
+	"system":    true,
+	"test":      true,
+	"twitch":    true,
+	"undefined": true,
+	"vanity":    true,
+	"www":       true,
+	"youtube":   true,
+}
+
+type vanityRequest struct {
+	Provider string `json:"provider" binding:"required"`
+	Token    string `json:"token" binding:"required"`
+	Code     string `json:"code"`
+}
+
+// readVanityRequest binds the request body and identifies its owner
+func (s *server) readVanityRequest(c *gin.Context) (*vanityRequest, string, bool) {
+	var req vanityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err)
+		c.String(400, fmt.Sprint(err))
+		return nil, "", false
+	}
+
+	id, err := s.identify(req.Provider, req.Token)
+	if err == errInvalidProvider {
+		c.String(400, "invalid provider")
+		return nil, "", false
+	}
+	if err != nil {
+		c.Error(err)
+		c.String(401, fmt.Sprint(err))
+		return nil, "", false
+	}
+
+	return &req, fmt.Sprintf("%v:%x", req.Provider, hashID(id)), true
+}
+
+// reserveVanity reserves a vanity code for the host, replacing any code it
+// had before
+func (s *server) reserveVanity(c *gin.Context) {
+	req, owner, ok := s.readVanityRequest(c)
+	if !ok {
+		return
+	}
+
+	code := strings.ToLower(req.Code)
+	if !vanityCodeFormat.MatchString(code) {
+		c.String(400, "code must be 3 to 25 letters, numbers or underscores")
+		return
+	}
+	if reservedVanityCodes[code] {
+		c.String(400, "code not allowed")
+		return
+	}
+
+	val, err := s.redis.reserveVanity(code, owner, vanityTTL)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	if val != 1 {
+		c.String(409, "code taken")
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"code": code,
+	})
+}
+
+// releaseVanity gives up the host's vanity code before it expires
+func (s *server) releaseVanity(c *gin.Context) {
+	_, owner, ok := s.readVanityRequest(c)
+	if !ok {
+		return
+	}
+
+	code, err := s.redis.releaseVanity(owner)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	if code == "" {
+		c.String(404, "no vanity code")
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"code": code,
+	})
+}
+
+// claimVanity starts a session on the host's vanity code without a problem
+// to solve
+func (s *server) claimVanity(c *gin.Context) {
+	_, owner, ok := s.readVanityRequest(c)
+	if !ok {
+		return
+	}
+
+	code, err := s.redis.vanityCode(owner)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	if code == "" {
+		c.String(404, "no vanity code")
+		return
+	}
+
+	if err := s.redis.touchVanity(code, owner, vanityTTL); err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	s.startSession(c, code, owner)
+}
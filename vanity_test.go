@@ -0,0 +1,164 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_server_vanity(t *testing.T) {
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+
+	router := gin.New()
+
+	Server(router.Group("/"))
+
+	// outside twitch and google every token identifies the same user while
+	// testing, so hosts are told apart by provider
+	send := func(method string, endpoint string, provider string, code string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"provider":%q,"token":"token","code":%q}`, provider, code)
+		req, _ := http.NewRequest(method, endpoint, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("reserve", func(t *testing.T) {
+		w := send("POST", "/session/vanity", "a", "MyChannel")
+		if w.Code != 200 {
+			t.Fatalf("reserveVanity returned %v, expected %v", w.Code, 200)
+		}
+		if w.Body.String() != `{"code":"mychannel"}` {
+			t.Errorf("reserveVanity returned %v", w.Body.String())
+		}
+		if !mr.Exists("vanity:mychannel") {
+			t.Errorf("reserveVanity didn't store the code")
+		}
+
+		if w := send("POST", "/session/vanity", "a", "mychannel"); w.Code != 200 {
+			t.Errorf("reserveVanity of own code returned %v, expected %v", w.Code, 200)
+		}
+	})
+
+	t.Run("invalid codes", func(t *testing.T) {
+		for _, code := range []string{"", "ab", "has space", "has-dash", strings.Repeat("a", 26), "admin", "Pogify"} {
+			if w := send("POST", "/session/vanity", "a", code); w.Code != 400 {
+				t.Errorf("reserveVanity(%q) returned %v, expected %v", code, w.Code, 400)
+			}
+		}
+		if code, _ := mr.Get("vanityOwner:a:" + fmt.Sprintf("%x", hashID("test"))); code != "mychannel" {
+			t.Errorf("invalid code replaced the host's code with %q", code)
+		}
+	})
+
+	t.Run("taken", func(t *testing.T) {
+		if w := send("POST", "/session/vanity", "b", "mychannel"); w.Code != 409 {
+			t.Errorf("reserveVanity of taken code returned %v, expected %v", w.Code, 409)
+		}
+
+		// a random code with a live session
+		mr.Set("session:abcde", "refresh")
+		defer mr.Del("session:abcde")
+		if w := send("POST", "/session/vanity", "b", "abcde"); w.Code != 409 {
+			t.Errorf("reserveVanity of a live session's code returned %v, expected %v", w.Code, 409)
+		}
+	})
+
+	t.Run("expires", func(t *testing.T) {
+		if ttl := mr.TTL("vanity:mychannel"); ttl != vanityTTL {
+			t.Errorf("reserveVanity set ttl %v, expected %v", ttl, vanityTTL)
+		}
+		if ttl := mr.TTL("vanityOwner:a:" + fmt.Sprintf("%x", hashID("test"))); ttl != vanityTTL {
+			t.Errorf("reserveVanity set owner ttl %v, expected %v", ttl, vanityTTL)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		if w := send("POST", "/session/vanity", "a", "other"); w.Code != 200 {
+			t.Fatalf("reserveVanity returned %v, expected %v", w.Code, 200)
+		}
+		if mr.Exists("vanity:mychannel") {
+			t.Errorf("reserveVanity didn't release the previous code")
+		}
+		if w := send("POST", "/session/vanity", "b", "mychannel"); w.Code != 200 {
+			t.Errorf("reserveVanity of released code returned %v, expected %v", w.Code, 200)
+		}
+	})
+
+	t.Run("claim", func(t *testing.T) {
+		mr.FastForward(time.Hour)
+		w := send("POST", "/session/vanity/claim", "a", "")
+		if w.Code != 200 {
+			t.Fatalf("claimVanity returned %v, expected %v", w.Code, 200)
+		}
+
+		var session struct {
+			Session      string `json:"session"`
+			RefreshToken string `json:"refreshToken"`
+			Token        string `json:"token"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &session)
+		if session.Session != "other" || session.Token == "" {
+			t.Errorf("claimVanity returned %v", w.Body.String())
+		}
+		if val, _ := mr.Get("session:other"); val != session.RefreshToken {
+			t.Errorf("claimVanity didn't store the refresh token")
+		}
+		if ttl := mr.TTL("vanity:other"); ttl != vanityTTL {
+			t.Errorf("claimVanity left ttl %v on the code, expected %v", ttl, vanityTTL)
+		}
+
+		if w := send("POST", "/session/vanity/claim", "a", ""); w.Code != 410 {
+			t.Errorf("claimVanity of an active session returned %v, expected %v", w.Code, 410)
+		}
+		if w := send("POST", "/session/vanity/claim", "c", ""); w.Code != 404 {
+			t.Errorf("claimVanity without a code returned %v, expected %v", w.Code, 404)
+		}
+	})
+
+	t.Run("claim right after ending", func(t *testing.T) {
+		mr.Del("session:other")
+		mr.Set("ended:other", fmt.Sprint(1<<62))
+		defer mr.Del("ended:other")
+
+		if w := send("POST", "/session/vanity/claim", "a", ""); w.Code != 409 {
+			t.Errorf("claimVanity of a just ended session returned %v, expected %v", w.Code, 409)
+		}
+	})
+
+	t.Run("release", func(t *testing.T) {
+		w := send("DELETE", "/session/vanity", "a", "")
+		if w.Code != 200 || w.Body.String() != `{"code":"other"}` {
+			t.Errorf("releaseVanity returned %v %v", w.Code, w.Body.String())
+		}
+		if mr.Exists("vanity:other") || mr.Exists("vanityOwner:a:"+fmt.Sprintf("%x", hashID("test"))) {
+			t.Errorf("releaseVanity didn't delete the code")
+		}
+
+		if w := send("DELETE", "/session/vanity", "a", ""); w.Code != 404 {
+			t.Errorf("releaseVanity without a code returned %v, expected %v", w.Code, 404)
+		}
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/vanity", strings.NewReader(`{"provider":"a","code":"abc"}`))
+		router.ServeHTTP(w, req)
+		if w.Code != 400 {
+			t.Errorf("reserveVanity without token returned %v, expected %v", w.Code, 400)
+		}
+	})
+}
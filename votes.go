@@ -0,0 +1,121 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestVotesEvent tells listeners a queued request's vote total changed
+const requestVotesEvent = "votes"
+
+// listenerVotes is how many anonymous listeners on one client can vote on a
+// request when LISTENER_POW is off
+const listenerVotes = 3
+
+type voteRequest struct {
+	Session  string `json:"session" binding:"required"`
+	Provider string `json:"provider" binding:"required"`
+	Token    string `json:"token" binding:"required"`
+	Request  string `json:"request" binding:"required"`
+	// Vote is 1 or -1, or 0 to take a vote back
+	Vote int `json:"vote" binding:"oneof=-1 0 1"`
+}
+
+// voteOnRequest records a listener's vote on a pending request. Each listener
+// has one vote per request and voting again replaces it.
+func (s *server) voteOnRequest(c *gin.Context) {
+	var v voteRequest
+	if err := c.ShouldBindJSON(&v); err != nil {
+		c.Error(err)
+		c.String(400, fmt.Sprint(err))
+		return
+	}
+
+	id, ok := s.identifyRequester(c, v.Session, v.Provider, v.Token)
+	if !ok {
+		return
+	}
+
+	voter := requesterID(v.Provider, id)
+	// without PoW listener tokens are cheap enough to stuff the ballot with
+	if v.Provider == providerListener && !s.listenerPoW {
+		ok, err := s.redis.rateLimitVote(v.Session, v.Request, s.clientIP(c), voter, listenerVotes)
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+		if !ok {
+			c.String(429, "too many listeners voting from this client")
+			return
+		}
+	}
+
+	res, votes, err := s.redis.voteRequest(v.Session, v.Request, voter, v.Vote)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	switch res {
+	case 0:
+		c.String(404, "no such request")
+		return
+	case -1:
+		c.String(409, "request isn't pending")
+		return
+	}
+
+	msg, _ := json.Marshal(envelope{
+		Time: MilliTime(time.Now()),
+		Type: requestVotesEvent,
+		Payload: gin.H{
+			"id":    v.Request,
+			"votes": votes,
+		},
+	})
+	// the vote is counted either way; listeners see it with the next change
+	if _, err := s.pubsub.Publish(v.Session, msg); err != nil {
+		log.Printf("Pubsub error with: %v", err)
+	}
+
+	c.JSON(200, gin.H{
+		"id":    v.Request,
+		"votes": votes,
+	})
+}
+
+// getRankedRequests lists a session's pending requests by votes, oldest first
+// on ties
+func (s *server) getRankedRequests(c *gin.Context) {
+	sessionID := c.Query("session")
+	if sessionID == "" {
+		c.String(400, "no session query")
+		return
+	}
+
+	if !s.authorizeListener(c, sessionID, c.GetHeader("X-Listener-Token")) {
+		return
+	}
+
+	requests, err := s.redis.queuedRequests(sessionID)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	pending := requests[:0]
+	for _, q := range requests {
+		if q.Status == requestPending {
+			pending = append(pending, q)
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].Votes > pending[j].Votes
+	})
+
+	c.JSON(200, gin.H{"requests": pending})
+}
@@ -0,0 +1,160 @@
+package pogifyapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_server_voteOnRequest(t *testing.T) {
+	mr, err := miniredis.Run()
+	defer mr.Close()
+	if err != nil {
+		t.Fatalf("MiniRedis error: %s", err)
+	}
+	os.Setenv("REDIS_URI", "redis://"+mr.Addr())
+	mr.Set("session:exist", "refresh")
+
+	router := gin.New()
+
+	Server(router.Group("/"))
+
+	send := func(method string, endpoint string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, endpoint, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+	listener := func() string {
+		var l struct {
+			Token string `json:"token"`
+		}
+		json.Unmarshal(send("POST", "/session/listener", `{"session":"exist"}`).Body.Bytes(), &l)
+		return l.Token
+	}
+	makeRequest := func(song string) queuedRequest {
+		w := send("POST", "/session/request", fmt.Sprintf(`{"session":"exist","provider":"listener","token":%q,"request":%q}`, listener(), song))
+		var q queuedRequest
+		json.Unmarshal(w.Body.Bytes(), &q)
+		return q
+	}
+	vote := func(token string, request string, vote int) *httptest.ResponseRecorder {
+		return send("POST", "/session/requests/vote", fmt.Sprintf(`{"session":"exist","provider":"listener","token":%q,"request":%q,"vote":%v}`, token, request, vote))
+	}
+	ranked := func() []queuedRequest {
+		w := send("GET", "/session/requests/ranked?session=exist", "")
+		if w.Code != 200 {
+			t.Fatalf("getRankedRequests returned %v, expected %v", w.Code, 200)
+		}
+		var res struct {
+			Requests []queuedRequest `json:"requests"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return res.Requests
+	}
+
+	first := makeRequest("spotify:track:4uLU6hMCjMI75M1A2tKUQC")
+	second := makeRequest("https://youtu.be/dQw4w9WgXcQ")
+	alice, bob := listener(), listener()
+
+	t.Run("invalid votes", func(t *testing.T) {
+		if w := vote(alice, first.ID, 2); w.Code != 400 {
+			t.Errorf("vote of 2 returned %v, expected %v", w.Code, 400)
+		}
+		if w := vote(alice, "nope", 1); w.Code != 404 {
+			t.Errorf("vote on an unknown request returned %v, expected %v", w.Code, 404)
+		}
+		if w := vote("not.a.token", first.ID, 1); w.Code != 401 {
+			t.Errorf("vote with an invalid token returned %v, expected %v", w.Code, 401)
+		}
+	})
+
+	t.Run("one vote each", func(t *testing.T) {
+		vote(alice, second.ID, 1)
+		vote(alice, second.ID, 1)
+		w := vote(bob, second.ID, 1)
+		if w.Code != 200 || w.Body.String() != fmt.Sprintf(`{"id":%q,"votes":2}`, second.ID) {
+			t.Errorf("vote returned %v: %v", w.Code, w.Body.String())
+		}
+
+		var e envelope
+		json.Unmarshal(_fakePublisher.last("exist"), &e)
+		if payload, _ := json.Marshal(e.Payload); e.Type != requestVotesEvent || e.Seq != 0 || string(payload) != fmt.Sprintf(`{"id":%q,"votes":2}`, second.ID) {
+			t.Errorf("vote published %v", string(_fakePublisher.last("exist")))
+		}
+
+		// changing a vote replaces it
+		vote(alice, first.ID, 1)
+		vote(alice, first.ID, -1)
+		if w := vote(bob, first.ID, 0); w.Body.String() != fmt.Sprintf(`{"id":%q,"votes":-1}`, first.ID) {
+			t.Errorf("vote returned %v", w.Body.String())
+		}
+	})
+
+	t.Run("ranked", func(t *testing.T) {
+		third := makeRequest("spotify:album:1DFixLWuPkv3KT3TnV35m3")
+
+		requests := ranked()
+		if len(requests) != 3 || requests[0].ID != second.ID || requests[1].ID != third.ID || requests[2].ID != first.ID {
+			t.Fatalf("getRankedRequests returned %+v", requests)
+		}
+		if requests[0].Votes != 2 || requests[1].Votes != 0 || requests[2].Votes != -1 {
+			t.Errorf("getRankedRequests returned votes %v, %v, %v", requests[0].Votes, requests[1].Votes, requests[2].Votes)
+		}
+
+		if w := send("GET", "/session/requests/ranked", ""); w.Code != 400 {
+			t.Errorf("getRankedRequests without session returned %v, expected %v", w.Code, 400)
+		}
+	})
+
+	t.Run("only pending requests", func(t *testing.T) {
+		mr.HSet("session:exist:requests", second.ID, fmt.Sprintf(`{"id":%q,"status":%q}`, second.ID, requestAccepted))
+
+		if w := vote(alice, second.ID, -1); w.Code != 409 {
+			t.Errorf("vote on an accepted request returned %v, expected %v", w.Code, 409)
+		}
+		for _, q := range ranked() {
+			if q.ID == second.ID {
+				t.Errorf("getRankedRequests returned an accepted request")
+			}
+		}
+	})
+
+	t.Run("listeners per client", func(t *testing.T) {
+		q := makeRequest("spotify:track:7GhIk7Il098yCjg4BQjzvb")
+		voters := []string{alice}
+		for i := 0; i < listenerVotes; i++ {
+			voters = append(voters, listener())
+		}
+
+		for _, l := range voters[:listenerVotes] {
+			if w := vote(l, q.ID, 1); w.Code != 200 {
+				t.Errorf("vote returned %v, expected %v", w.Code, 200)
+			}
+		}
+		if w := vote(voters[listenerVotes], q.ID, 1); w.Code != 429 {
+			t.Errorf("vote from one listener too many returned %v, expected %v", w.Code, 429)
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/session/requests/vote", strings.NewReader(fmt.Sprintf(`{"session":"exist","provider":"listener","token":%q,"request":%q,"vote":1}`, voters[listenerVotes], q.ID)))
+		req.Header.Add("X-Forwarded-For", "203.0.113.7")
+		router.ServeHTTP(w, req)
+		if w.Code != 429 {
+			t.Errorf("vote with a forwarded address returned %v, expected %v", w.Code, 429)
+		}
+		// listeners that voted can still change their vote
+		if w := vote(alice, q.ID, -1); w.Code != 200 {
+			t.Errorf("changing a vote returned %v, expected %v", w.Code, 200)
+		}
+		if !mr.Exists("session:exist:requestLimits") {
+			t.Errorf("vote limit isn't tracked on the session")
+		}
+	})
+}